@@ -63,6 +63,28 @@ List of sub-commands:
 		Note: this is a very experimental feature, and may not
 		be very useful in practice.
 
+	progress
+		List the operations the LSP servers are busy with (e.g.
+		loading packages), such as when a command is taking long
+		to complete.
+
+	reload
+		Reload the acme-lsp configuration file. Only the LSP servers
+		whose configuration changed are restarted. The others are
+		sent the changed Settings.
+
+	servers
+		List the configured LSP servers and the status of the
+		running instances.
+
+	servers restart <key>
+		Restart the LSP servers with the given key in the
+		configuration, and open the files handled by them again.
+
+	servers stop <key>
+		Stop the LSP servers with the given key in the
+		configuration. They will be started again when needed.
+
 	ws
 		List current set of workspace directories.
 
@@ -5,12 +5,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	p9client "github.com/fhs/9fans-go/plan9/client"
 	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/jsonrpc2"
@@ -88,6 +91,28 @@ List of sub-commands:
 		Note: this is a very experimental feature, and may not
 		be very useful in practice.
 
+	progress
+		List the operations the LSP servers are busy with (e.g.
+		loading packages), such as when a command is taking long
+		to complete.
+
+	reload
+		Reload the acme-lsp configuration file. Only the LSP servers
+		whose configuration changed are restarted. The others are
+		sent the changed Settings.
+
+	servers
+		List the configured LSP servers and the status of the
+		running instances.
+
+	servers restart <key>
+		Restart the LSP servers with the given key in the
+		configuration, and open the files handled by them again.
+
+	servers stop <key>
+		Stop the LSP servers with the given key in the
+		configuration. They will be started again when needed.
+
 	ws
 		List current set of workspace directories.
 
@@ -172,6 +197,23 @@ func run(cfg *config.Config, args []string) error {
 				Removed: dirs,
 			},
 		})
+	case "progress":
+		status, err := server.WorkDoneProgress(ctx)
+		if err != nil {
+			return err
+		}
+		if len(status) == 0 {
+			fmt.Fprintf(os.Stderr, "LSP servers are not reporting any progress\n")
+		}
+		for i := range status {
+			s := &status[i]
+			fmt.Printf("%v: %v (%v)\n", s.Server, s, time.Since(s.Start).Round(time.Second))
+		}
+		return nil
+	case "reload":
+		return server.Reload(ctx)
+	case "servers":
+		return servers(ctx, server, args[1:])
 	case "win", "assist": // "win" is deprecated
 		args = args[1:]
 		sm := &acmelsp.UnitServerMatcher{Server: server}
@@ -253,6 +295,58 @@ func dirsOrCurrentDir(dirs []string) ([]protocol.WorkspaceFolder, error) {
 	return lsp.DirsToWorkspaceFolders(dirs)
 }
 
+func servers(ctx context.Context, server proxy.Server, args []string) error {
+	if len(args) == 0 {
+		status, err := server.Servers(ctx)
+		if err != nil {
+			return err
+		}
+		for i := range status {
+			printServerStatus(os.Stdout, &status[i])
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: L servers [restart|stop <key>]")
+	}
+	params := &proxy.ServerKeyParams{Key: args[1]}
+	switch args[0] {
+	case "restart":
+		return server.RestartServer(ctx, params)
+	case "stop":
+		return server.StopServer(ctx, params)
+	}
+	return fmt.Errorf("unknown servers command %q", args[0])
+}
+
+func printServerStatus(w io.Writer, s *proxy.ServerStatus) {
+	cmd := s.Address
+	if len(s.Command) > 0 {
+		cmd = strings.Join(s.Command, " ")
+	}
+	fmt.Fprintf(w, "%v %v %v\n", s.Key, s.Pattern, cmd)
+	if s.Root != "" {
+		fmt.Fprintf(w, "\troot %v\n", s.Root)
+	}
+	if !s.Running {
+		fmt.Fprintf(w, "\tnot running\n")
+		return
+	}
+	if s.PID > 0 {
+		fmt.Fprintf(w, "\tpid %v, ", s.PID)
+	} else {
+		fmt.Fprintf(w, "\t")
+	}
+	fmt.Fprintf(w, "up %v, %v restarts\n", time.Since(s.Start).Round(time.Second), s.Restarts)
+	if s.Name != "" {
+		fmt.Fprintf(w, "\t%v %v\n", s.Name, s.Version)
+	}
+	fmt.Fprintf(w, "\t%v open documents\n", len(s.OpenDocuments))
+	for _, name := range s.OpenDocuments {
+		fmt.Fprintf(w, "\t\t%v\n", name)
+	}
+}
+
 func getFocusedWinID(addr string) (string, error) {
 	winid := os.Getenv("winid")
 	if winid == "" {
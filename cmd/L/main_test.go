@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/fhs/acme-lsp/internal/lsp/proxy"
 )
 
 func TestGetFocusedWinIDFromEnv(t *testing.T) {
@@ -66,3 +70,40 @@ func TestGetFocusedWinIDFromServer(t *testing.T) {
 		t.Errorf("$winid is %v; want %v", got, want)
 	}
 }
+
+func TestPrintServerStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status *proxy.ServerStatus
+		want   string
+	}{
+		{
+			&proxy.ServerStatus{
+				Key:     "pyls",
+				Pattern: `\.py$`,
+				Command: []string{"pyls", "-v"},
+			},
+			"pyls \\.py$ pyls -v\n\tnot running\n",
+		},
+		{
+			&proxy.ServerStatus{
+				Key:           "gopls",
+				Pattern:       `\.go$`,
+				Address:       "localhost:4389",
+				Root:          "/home/gopher/mod",
+				Running:       true,
+				Start:         time.Now(),
+				Restarts:      2,
+				OpenDocuments: []string{"/a.go", "/b.go"},
+				Name:          "gopls",
+				Version:       "v1.0.0",
+			},
+			"gopls \\.go$ localhost:4389\n\troot /home/gopher/mod\n\tup 0s, 2 restarts\n\tgopls v1.0.0\n\t2 open documents\n\t\t/a.go\n\t\t/b.go\n",
+		},
+	} {
+		var b bytes.Buffer
+		printServerStatus(&b, tc.status)
+		if got := b.String(); got != tc.want {
+			t.Errorf("printed %q; want %q", got, tc.want)
+		}
+	}
+}
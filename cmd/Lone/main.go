@@ -95,7 +95,7 @@ func main() {
 }
 
 func run(cfg *config.Config, args []string) error {
-	serverSet, err := acmelsp.NewServerSet(cfg, acmelsp.NewDiagnosticsWriter())
+	serverSet, err := acmelsp.NewServerSet(cfg, acmelsp.NewDiagnosticsWriter(cfg), nil)
 	if err != nil {
 		return fmt.Errorf("failed to create server set: %v", err)
 	}
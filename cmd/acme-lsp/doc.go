@@ -14,20 +14,58 @@ located at UserConfigDir/acme-lsp/config.toml (the -showconfig flag
 prints the exact location).  The command line flags will override the
 configuration values.  The configuration options are described here:
 https://godoc.org/github.com/fhs/acme-lsp/internal/lsp/acmelsp/config#File
+A project configuration file named .acme-lsp.toml overrides some of
+the configuration for files within the directory containing it, as
+described here:
+https://godoc.org/github.com/fhs/acme-lsp/internal/lsp/acmelsp/config#Project
+The configuration file is reloaded by "L reload", or when it changes if
+the ReloadOnChange configuration option is set. Project configuration
+files are only reloaded by "L reload". The -checkconfig flag checks
+the configuration file, and the project configuration file of the
+current directory, for problems like unknown keys, invalid filename
+patterns, or server commands that aren't installed.
 
 Acme-lsp executes or connects to a set of LSP servers described in the
 configuration file or in the -server or -dial flags. It then listens for
 messages sent by the L command, which direct acme-lsp to run commands
 on the LSP servers and apply/show the results in acme. The communication
 protocol used here is an implementation detail that is subject to change.
+If a file is handled by more than one LSP server, all of them are told
+about changes to the file and their diagnostics are merged, but each
+request is sent to only one of them, chosen by the Priority configuration
+option and the capabilities advertised by the servers.
 
 Acme-lsp watches for files created (New), loaded (Get), saved (Put), or
 deleted (Del) in acme, and tells the LSP server about these changes. The
 LSP server in turn responds by sending diagnostics information (compiler
 errors, lint errors, etc.) which are shown in a "/LSP/Diagnostics" window.
+Other messages sent by the LSP server (e.g. warnings about the workspace
+setup) are shown in a "/LSP/Messages" window, filtered by the MessageLevel
+configuration option. The beginning and end of long running work reported
+by the LSP server (e.g. loading packages) are shown there regardless of it.
 Also, when Put is executed in an acme window, acme-lsp will organize
 import paths in the window and format it by default. This behavior can
-be changed by the FormatOnPut and CodeActionsOnPut configuration options.
+be changed by the FormatOnPut and CodeActionsOnPut configuration options,
+either globally or for the files handled by a server or a filename handler.
+By default, the window is formatted after acme writes the file, so it has
+to be Put again. If the InterceptPut configuration option is set, acme-lsp
+formats the window, and applies the edits the LSP server requests with
+willSaveWaitUntil, before acme writes the file. The window isn't formatted
+if the LSP server reported syntax errors for the file (see the
+SkipFormatOnErrors configuration option), and the formatting edits
+aren't applied if they would change too much of the file (see the
+MaxFormatChange configuration option); the diff is shown in the
+"/LSP/Messages" window instead.
+
+Files changed outside acme (e.g. by git checkout or code generators) are
+also watched in the workspace folders of the LSP servers that ask for it,
+using inotify on Linux and polling elsewhere, and the servers are told
+about the files created, changed or deleted. Clean acme windows of the
+changed files are reloaded. Directories whose name begins with a dot
+(e.g. ".git") aren't watched.
+
+On interrupt or termination signal, acme-lsp asks the LSP servers to
+shutdown and exit, and kills the ones that don't exit in time.
 
 	Usage: acme-lsp [flags]
 
@@ -35,10 +73,15 @@ be changed by the FormatOnPut and CodeActionsOnPut configuration options.
     	address where acme is serving 9P file system (default "/tmp/ns.fhs.:0/acme")
   -acme.net string
     	network where acme is serving 9P file system (default "unix")
+  -checkconfig
+    	check configuration files for problems and exit
   -debug
     	turn on debugging prints (deprecated: use -v)
   -dial value
     	language server address for filename match (e.g. '\.go$:localhost:4389')
+  -preset string
+    	comma-separated list of predefined language server
+    	configurations to use. The available presets are: bash-language-server, clangd, gopls, haskell-language-server, lua-language-server, ocamllsp, pyls, pylsp, pyright, rust-analyzer, typescript-language-server, yaml-language-server, zls
   -proxy.addr string
     	address used for communication between acme-lsp and L (default "/tmp/ns.fhs.:0/acme-lsp.rpc")
   -proxy.net string
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/fhs/acme-lsp/internal/acme"
 )
@@ -64,6 +65,20 @@ func (w *Win) CurrentAddr() (q0, q1 int, err error) {
 	return w.ReadAddr()
 }
 
+// IsDirty returns true if the window was modified since the file was
+// last read or written.
+func (w *Win) IsDirty() (bool, error) {
+	ctl, err := w.ReadAll("ctl")
+	if err != nil {
+		return false, err
+	}
+	f := strings.Fields(string(ctl))
+	if len(f) < 5 {
+		return false, fmt.Errorf("invalid ctl file of window %v: %q", w.ID(), ctl)
+	}
+	return f[4] == "1", nil
+}
+
 func (w *Win) FileReadWriter(filename string) io.ReadWriter {
 	return &winReadWriter{
 		w:    w.Win,
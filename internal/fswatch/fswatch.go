@@ -0,0 +1,279 @@
+// Package fswatch reports changes to the files in directory trees.
+//
+// It uses inotify on Linux. On other systems, or if inotify fails (e.g.
+// because the limit on the number of watches is reached), it polls the
+// directory trees periodically.
+package fswatch
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op is the kind of change made to a file. The values are the same as
+// the LSP FileChangeType values.
+type Op int
+
+const (
+	Create Op = iota + 1 // file was created
+	Change               // file was changed
+	Delete               // file was deleted
+)
+
+func (op Op) String() string {
+	switch op {
+	case Create:
+		return "create"
+	case Change:
+		return "change"
+	case Delete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Event is a change to a file.
+type Event struct {
+	Name string // absolute path of the file
+	Op   Op
+}
+
+var errNotSupported = errors.New("inotify is not supported")
+
+// batchDelay is how long events are collected after the first one, so
+// that the changes made by a program (e.g. git checkout) are reported
+// together.
+const batchDelay = 200 * time.Millisecond
+
+// backend watches directory trees for changes.
+type backend interface {
+	// setRoots replaces the roots of the directory trees watched.
+	setRoots(roots []string) error
+
+	// close stops watching.
+	close() error
+}
+
+// Watcher watches directory trees for changes to the files in them.
+// Directories whose name begins with a dot (e.g. ".git") aren't watched.
+type Watcher struct {
+	pollInterval time.Duration
+	events       chan []Event
+	done         chan struct{} // closed by Close
+
+	bmu   sync.Mutex // guards b and roots
+	b     backend
+	roots []string
+
+	mu      sync.Mutex    // guards pending, timer and closed
+	pending map[string]Op // events not reported yet, keyed by name
+	timer   *time.Timer   // reports the pending events
+	closed  bool
+
+	sendMu sync.Mutex // held while sending events, so that they're in order
+}
+
+// New returns a new watcher, which initially doesn't watch any
+// directories. If inotify can't be used, the directories are polled
+// every pollInterval.
+func New(pollInterval time.Duration) *Watcher {
+	w := &Watcher{
+		pollInterval: pollInterval,
+		events:       make(chan []Event),
+		done:         make(chan struct{}),
+		pending:      make(map[string]Op),
+	}
+	b, err := newNotifier(w.add)
+	if err != nil {
+		if err != errNotSupported {
+			log.Printf("fswatch: %v; polling for changes instead", err)
+		}
+		b = newPoller(pollInterval, w.add)
+	}
+	w.b = b
+	return w
+}
+
+// Events returns the channel on which the changes are reported. Events
+// for the same file are merged, so that there is at most one event for
+// each file in a batch.
+func (w *Watcher) Events() <-chan []Event {
+	return w.events
+}
+
+// SetRoots replaces the roots of the directory trees watched. Roots
+// that are inside other roots are ignored.
+func (w *Watcher) SetRoots(roots []string) error {
+	roots = cleanRoots(roots)
+
+	w.bmu.Lock()
+	defer w.bmu.Unlock()
+
+	if w.b == nil || equalStrings(roots, w.roots) {
+		return nil
+	}
+	err := w.b.setRoots(roots)
+	if err == nil {
+		w.roots = roots
+		return nil
+	}
+	if _, ok := w.b.(*poller); ok {
+		return err
+	}
+	log.Printf("fswatch: %v; polling for changes instead", err)
+	w.b.close()
+	w.b = newPoller(w.pollInterval, w.add)
+	w.roots = roots
+	return w.b.setRoots(roots)
+}
+
+// Close stops watching and closes the events channel.
+func (w *Watcher) Close() error {
+	w.bmu.Lock()
+	defer w.bmu.Unlock()
+
+	if w.b == nil {
+		return nil
+	}
+	err := w.b.close()
+	w.b = nil
+
+	w.mu.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+
+	close(w.done)
+	w.sendMu.Lock()
+	close(w.events)
+	w.sendMu.Unlock()
+	return err
+}
+
+// add merges event ev with the pending events, and schedules the pending
+// events to be reported.
+func (w *Watcher) add(ev Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	prev, ok := w.pending[ev.Name]
+	switch {
+	case !ok:
+		w.pending[ev.Name] = ev.Op
+	case prev == Create && ev.Op == Delete:
+		delete(w.pending, ev.Name) // temporary file
+	case prev == Create:
+		// Still a new file.
+	case prev == Delete && ev.Op == Create:
+		w.pending[ev.Name] = Change // replaced (e.g. renamed over)
+	default:
+		w.pending[ev.Name] = ev.Op
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(batchDelay, w.flush)
+	}
+}
+
+// flush reports the pending events.
+func (w *Watcher) flush() {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+
+	w.mu.Lock()
+	w.timer = nil
+	if w.closed || len(w.pending) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	events := make([]Event, 0, len(w.pending))
+	for name, op := range w.pending {
+		events = append(events, Event{Name: name, Op: op})
+	}
+	w.pending = make(map[string]Op)
+	w.mu.Unlock()
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Name < events[j].Name
+	})
+	select {
+	case w.events <- events:
+	case <-w.done:
+	}
+}
+
+// cleanRoots returns the sorted absolute paths of roots, without the
+// ones inside other roots.
+func cleanRoots(roots []string) []string {
+	var abs []string
+	for _, r := range roots {
+		a, err := filepath.Abs(r)
+		if err != nil {
+			continue
+		}
+		abs = append(abs, a)
+	}
+	sort.Strings(abs)
+
+	var result []string
+	for _, r := range abs {
+		if len(result) > 0 && within(result[len(result)-1], r) {
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// within returns true if path is dir or a file inside it.
+func within(dir, path string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// walk calls f for each file and directory in the tree rooted at root,
+// skipping the directories that aren't watched.
+func walk(root string, f func(path string, fi os.FileInfo)) {
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil // e.g. deleted or permission denied
+		}
+		if fi.IsDir() && path != root && skipDir(fi.Name()) {
+			return filepath.SkipDir
+		}
+		f(path, fi)
+		return nil
+	})
+}
+
+// skipDir returns true if the directory with the given name isn't watched.
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,128 @@
+package fswatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCleanRoots(t *testing.T) {
+	got := cleanRoots([]string{"/a/b", "/c", "/a", "/ab", "/a/b/c"})
+	want := []string{"/a", "/ab", "/c"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("cleanRoots returned %v; want %v", got, want)
+	}
+}
+
+func TestWatcherMerge(t *testing.T) {
+	w := &Watcher{
+		events:  make(chan []Event, 1),
+		done:    make(chan struct{}),
+		pending: make(map[string]Op),
+	}
+	for _, ev := range []Event{
+		{"/a", Create},
+		{"/a", Change},
+		{"/b", Create},
+		{"/b", Delete},
+		{"/c", Delete},
+		{"/c", Create},
+		{"/d", Change},
+		{"/d", Delete},
+	} {
+		w.add(ev)
+	}
+	w.timer.Stop()
+	w.flush()
+	got := <-w.events
+	want := []Event{
+		{"/a", Create},
+		{"/c", Change},
+		{"/d", Delete},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("events are %v; want %v", got, want)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	t.Run("notifier", func(t *testing.T) {
+		testWatcher(t, New(time.Hour))
+	})
+	t.Run("poller", func(t *testing.T) {
+		w := New(time.Hour)
+		w.b.close()
+		w.b = newPoller(20*time.Millisecond, w.add)
+		testWatcher(t, w)
+	})
+}
+
+func testWatcher(t *testing.T, w *Watcher) {
+	defer w.Close()
+
+	dir, err := ioutil.TempDir("", "fswatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, s string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkdir := func(name string) {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkdir(".git")
+	mkdir("sub")
+	write("old.go", "package main\n")
+	write("sub/x.go", "package sub\n")
+
+	if err := w.SetRoots([]string{dir, filepath.Join(dir, "sub")}); err != nil {
+		t.Fatalf("SetRoots failed: %v", err)
+	}
+
+	// Make sure the poller sees the new size and modification time.
+	time.Sleep(50 * time.Millisecond)
+	write("new.go", "package main\n")
+	write("sub/x.go", "package sub // changed\n")
+	write(".git/HEAD", "ref: refs/heads/master\n")
+	mkdir("sub/gen")
+	write("sub/gen/y.go", "package gen\n")
+	if err := os.Remove(filepath.Join(dir, "old.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Op{
+		filepath.Join(dir, "new.go"):       Create,
+		filepath.Join(dir, "sub/x.go"):     Change,
+		filepath.Join(dir, "sub/gen"):      Create,
+		filepath.Join(dir, "sub/gen/y.go"): Create,
+		filepath.Join(dir, "old.go"):       Delete,
+	}
+	got := make(map[string]Op)
+	timeout := time.After(5 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case events := <-w.Events():
+			for _, ev := range events {
+				got[ev.Name] = ev.Op
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for events; got %v, want %v", got, want)
+		}
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("events are %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+// +build linux
+
+package fswatch
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// notifier is a backend that uses inotify. Each directory in the trees
+// is watched, including the ones created after the trees are added.
+type notifier struct {
+	emit func(Event)
+	f    *os.File // inotify instance
+	fd   int
+
+	mu    sync.Mutex
+	roots []string
+	wds   map[int32]string // watched directories, keyed by watch descriptor
+	dirs  map[string]int32 // watch descriptors, keyed by directory
+}
+
+func newNotifier(emit func(Event)) (backend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &notifier{
+		emit: emit,
+		// The file is pollable because it's in non-blocking mode,
+		// so closing it interrupts Read.
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		fd:   fd,
+		wds:  make(map[int32]string),
+		dirs: make(map[string]int32),
+	}
+	go n.run()
+	return n, nil
+}
+
+func (n *notifier) setRoots(roots []string) error {
+	n.mu.Lock()
+	old := n.roots
+	n.roots = roots
+	n.mu.Unlock()
+
+	for _, r := range old {
+		if !containsString(roots, r) {
+			n.removeTree(r)
+		}
+	}
+	for _, r := range roots {
+		if !containsString(old, r) {
+			if err := n.addTree(r, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *notifier) close() error {
+	return n.f.Close()
+}
+
+// addTree watches the directories in the tree rooted at root. If create
+// is true, the files in the tree are reported as created.
+func (n *notifier) addTree(root string, create bool) error {
+	var firstErr error
+	walk(root, func(path string, fi os.FileInfo) {
+		if fi.IsDir() {
+			if err := n.addWatch(path); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if create && path != root {
+			n.emit(Event{Name: path, Op: Create})
+		}
+	})
+	return firstErr
+}
+
+// addWatch watches directory dir. Directories that were deleted or
+// can't be read are ignored.
+func (n *notifier) addWatch(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+	switch err {
+	case nil:
+	case syscall.ENOENT, syscall.EACCES, syscall.ENOTDIR:
+		return nil
+	default:
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	n.mu.Lock()
+	n.wds[int32(wd)] = dir
+	n.dirs[dir] = int32(wd)
+	n.mu.Unlock()
+	return nil
+}
+
+// removeTree stops watching the directories in the tree rooted at root.
+func (n *notifier) removeTree(root string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for dir, wd := range n.dirs {
+		if within(root, dir) {
+			syscall.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.dirs, dir)
+			delete(n.wds, wd)
+		}
+	}
+}
+
+func (n *notifier) run() {
+	var buf [64 * 1024]byte
+	for {
+		k, err := n.f.Read(buf[:])
+		if err != nil {
+			return // closed
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= k; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			off += syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[off:off+int(ev.Len)]), "\x00")
+			off += int(ev.Len)
+			n.handle(ev.Wd, ev.Mask, name)
+		}
+	}
+}
+
+// handle handles the event with the given mask for file name in the
+// directory watched by watch descriptor wd.
+func (n *notifier) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		log.Printf("fswatch: inotify event queue overflowed; some changes were lost")
+		return
+	}
+	n.mu.Lock()
+	dir, ok := n.wds[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		// The directory was deleted or it's no longer watched.
+		if ok {
+			delete(n.wds, wd)
+			if n.dirs[dir] == wd {
+				delete(n.dirs, dir)
+			}
+		}
+		ok = false
+	}
+	n.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	isDir := mask&syscall.IN_ISDIR != 0
+	if isDir && skipDir(name) {
+		return
+	}
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		n.emit(Event{Name: path, Op: Create})
+		if isDir {
+			if err := n.addTree(path, true); err != nil {
+				log.Printf("fswatch: %v", err)
+			}
+		}
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		if isDir {
+			n.removeTree(path)
+		}
+		n.emit(Event{Name: path, Op: Delete})
+	case mask&syscall.IN_MODIFY != 0:
+		if !isDir {
+			n.emit(Event{Name: path, Op: Change})
+		}
+	}
+}
+
+func containsString(a []string, s string) bool {
+	for _, t := range a {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+// +build !linux
+
+package fswatch
+
+func newNotifier(emit func(Event)) (backend, error) {
+	return nil, errNotSupported
+}
@@ -0,0 +1,124 @@
+package fswatch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// fileState is the state of a file compared by the poller.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+// poller is a backend that periodically compares the state of the files
+// in the directory trees with the previous state.
+type poller struct {
+	emit func(Event)
+	done chan struct{}
+
+	mu    sync.Mutex
+	trees map[string]map[string]fileState // state of files, keyed by root and path
+}
+
+func newPoller(interval time.Duration, emit func(Event)) *poller {
+	p := &poller{
+		emit:  emit,
+		done:  make(chan struct{}),
+		trees: make(map[string]map[string]fileState),
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *poller) run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.poll()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// poll compares the state of the files with the previous state and
+// emits the differences.
+func (p *poller) poll() {
+	p.mu.Lock()
+	roots := make(map[string]map[string]fileState, len(p.trees))
+	for root, prev := range p.trees {
+		roots[root] = prev
+	}
+	p.mu.Unlock()
+
+	for root, prev := range roots {
+		cur := scan(root)
+		p.mu.Lock()
+		if _, ok := p.trees[root]; !ok {
+			p.mu.Unlock()
+			continue // no longer watched
+		}
+		p.trees[root] = cur
+		p.mu.Unlock()
+
+		for path, st := range cur {
+			old, ok := prev[path]
+			switch {
+			case !ok:
+				p.emit(Event{Name: path, Op: Create})
+			case !st.isDir && (!st.modTime.Equal(old.modTime) || st.size != old.size):
+				p.emit(Event{Name: path, Op: Change})
+			}
+		}
+		for path := range prev {
+			if _, ok := cur[path]; !ok {
+				p.emit(Event{Name: path, Op: Delete})
+			}
+		}
+	}
+}
+
+// scan returns the state of the files in the tree rooted at root.
+func scan(root string) map[string]fileState {
+	files := make(map[string]fileState)
+	walk(root, func(path string, fi os.FileInfo) {
+		if path == root {
+			return
+		}
+		files[path] = fileState{
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			isDir:   fi.IsDir(),
+		}
+	})
+	return files
+}
+
+func (p *poller) setRoots(roots []string) error {
+	p.mu.Lock()
+	old := p.trees
+	p.mu.Unlock()
+
+	trees := make(map[string]map[string]fileState)
+	for _, root := range roots {
+		if files, ok := old[root]; ok {
+			trees[root] = files
+		} else {
+			trees[root] = scan(root)
+		}
+	}
+	p.mu.Lock()
+	p.trees = trees
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *poller) close() error {
+	close(p.done)
+	return nil
+}
@@ -3,6 +3,7 @@ package acmelsp
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -41,7 +42,7 @@ func WindowRemoteCmd(ss *ServerSet, fm *FileManager, winid int) (*RemoteCmd, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to get text position: %v", err)
 	}
-	srv, found, err := ss.StartForFile(fname)
+	_, found, err := ss.StartForFile(fname)
 	if err != nil {
 		return nil, fmt.Errorf("cound not start language server: %v", err)
 	}
@@ -55,7 +56,7 @@ func WindowRemoteCmd(ss *ServerSet, fm *FileManager, winid int) (*RemoteCmd, err
 		return nil, fmt.Errorf("DidChange failed: %v", err)
 	}
 
-	return NewRemoteCmd(srv.Client, winid), nil
+	return NewRemoteCmd(&proxyServer{ss: ss, fm: fm}, winid), nil
 }
 
 func getLine(p string, l int) string {
@@ -142,6 +143,13 @@ type FormatServer interface {
 
 // CodeActionAndFormat runs the given code actions and then formats the file f.
 func CodeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol.TextDocumentIdentifier, f text.File, actions []protocol.CodeActionKind) error {
+	return codeActionAndFormat(ctx, server, doc, f, actions, nil)
+}
+
+// codeActionAndFormat is like CodeActionAndFormat, but the formatting
+// edits are only applied if check, if not nil, returns a nil error for
+// the text of f before and after the edits.
+func codeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol.TextDocumentIdentifier, f text.File, actions []protocol.CodeActionKind, check func(before, after []byte) error) error {
 	initres, err := server.InitializeResult(ctx, doc)
 	if err != nil {
 		return err
@@ -180,32 +188,28 @@ func CodeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol
 				}
 			}
 		}
-		if len(actions) > 0 {
-			// Our file may or may not be among the workspace edits for import fixes.
-			// We assume it is among the edits.
-			// TODO(fhs): Skip if our file didn't have import changes.
-			rd, err := f.Reader()
-			if err != nil {
-				return err
-			}
-			b, err := ioutil.ReadAll(rd)
-			if err != nil {
-				return err
-			}
-			server.DidChange(ctx, &protocol.DidChangeTextDocumentParams{
-				TextDocument: protocol.VersionedTextDocumentIdentifier{
-					TextDocumentIdentifier: *doc,
-				},
-				ContentChanges: []protocol.TextDocumentContentChangeEvent{
-					{
-						Text: string(b),
-					},
-				},
-			})
-			if err != nil {
-				return err
-			}
-		}
+	}
+
+	// Our file may have been changed by the code actions, or since the
+	// server was last told about it. Make sure the formatting edits are
+	// computed for the current text, and that the text doesn't change
+	// until the edits are applied.
+	before, err := readFile(f)
+	if err != nil {
+		return err
+	}
+	err = server.DidChange(ctx, &protocol.DidChangeTextDocumentParams{
+		TextDocument: protocol.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: *doc,
+		},
+		ContentChanges: []protocol.TextDocumentContentChangeEvent{
+			{
+				Text: string(before),
+			},
+		},
+	})
+	if err != nil {
+		return err
 	}
 	edits, err := server.Formatting(ctx, &protocol.DocumentFormattingParams{
 		TextDocument: *doc,
@@ -213,12 +217,40 @@ func CodeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol
 	if err != nil {
 		return err
 	}
+	if len(edits) == 0 {
+		return nil
+	}
+	b, err := readFile(f)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b, before) {
+		return fmt.Errorf("file changed while formatting; not applying edits")
+	}
+	if check != nil {
+		after, err := text.ApplyEdits(before, edits)
+		if err != nil {
+			return fmt.Errorf("failed to apply edits: %v", err)
+		}
+		if err := check(before, after); err != nil {
+			return err
+		}
+	}
 	if err := text.Edit(f, edits); err != nil {
 		return fmt.Errorf("failed to apply edits: %v", err)
 	}
 	return nil
 }
 
+// readFile returns the text of file f.
+func readFile(f text.File) ([]byte, error) {
+	rd, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(rd)
+}
+
 func editWorkspace(we *protocol.WorkspaceEdit) error {
 	if we == nil {
 		return nil // no changes to apply
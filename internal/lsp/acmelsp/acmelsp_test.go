@@ -125,7 +125,7 @@ func TestParseFlagSet(t *testing.T) {
 			if err != nil {
 				t.Fatalf("failed to parse flags: %v", err)
 			}
-			ss, err := NewServerSet(cfg, NewDiagnosticsWriter())
+			ss, err := NewServerSet(cfg, NewDiagnosticsWriter(cfg), nil)
 			if err != nil {
 				t.Fatalf("ParseFlagSet failed: %v", err)
 			}
@@ -159,3 +159,48 @@ func TestParseFlagSet(t *testing.T) {
 		})
 	}
 }
+
+func TestLineAt(t *testing.T) {
+	text := []rune("Info: run go mod tidy?\n\n\tRun go mod tidy\n\tNo\n")
+	for _, tc := range []struct {
+		q    int
+		want string
+	}{
+		{0, "Info: run go mod tidy?"},
+		{23, ""},
+		{30, "\tRun go mod tidy"},
+		{42, "\tNo"},
+		{len(text), ""},
+		{-1, ""},
+	} {
+		if got := lineAt(text, tc.q); got != tc.want {
+			t.Errorf("lineAt(%q, %v) is %q; want %q", string(text), tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestCheckFormatChange(t *testing.T) {
+	lines := func(n int, s string) []byte {
+		return []byte(strings.Repeat(s+"\n", n))
+	}
+	for _, tc := range []struct {
+		name          string
+		max           float64
+		before, after []byte
+		ok            bool
+	}{
+		{"unchanged", 0.5, lines(100, "a"), lines(100, "a"), true},
+		{"few lines", 0.5, lines(12, "a"), lines(12, "b"), false},
+		{"at most minFormatChange lines", 0.5, lines(minFormatChange, "a"), lines(minFormatChange, "b"), true},
+		{"more than minFormatChange lines", 0.5, lines(minFormatChange+1, "a"), lines(minFormatChange+1, "b"), false},
+		{"small fraction", 0.5, append(lines(40, "a"), lines(20, "a")...), append(lines(40, "a"), lines(20, "b")...), true},
+		{"large fraction", 0.5, lines(60, "a"), append(lines(40, "a"), lines(40, "b")...), false},
+		{"replaced", 0.5, lines(60, "a"), lines(60, "b"), false},
+		{"any change", 1, lines(60, "a"), lines(60, "b"), true},
+	} {
+		err := checkFormatChange(tc.max, tc.before, tc.after)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%v: checkFormatChange returned %v", tc.name, err)
+		}
+	}
+}
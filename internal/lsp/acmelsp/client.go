@@ -7,9 +7,13 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/jsonrpc2"
+	"github.com/fhs/acme-lsp/internal/lsp"
 	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
 	"github.com/fhs/acme-lsp/internal/lsp/protocol"
 	"github.com/fhs/acme-lsp/internal/lsp/proxy"
@@ -20,11 +24,16 @@ var Verbose = false
 
 type DiagnosticsWriter interface {
 	WriteDiagnostics(params *protocol.PublishDiagnosticsParams)
+
+	// DropDiagnostics discards diagnostics for the document uri,
+	// or for all documents within uri if it's a directory.
+	DropDiagnostics(uri protocol.DocumentURI)
 }
 
 // clientHandler handles JSON-RPC requests and notifications.
 type clientHandler struct {
 	cfg        *ClientConfig
+	client     *Client
 	hideDiag   bool
 	diagWriter DiagnosticsWriter
 	diag       map[protocol.DocumentURI][]protocol.Diagnostic
@@ -32,16 +41,23 @@ type clientHandler struct {
 }
 
 func (h *clientHandler) ShowMessage(ctx context.Context, params *protocol.ShowMessageParams) error {
+	if h.cfg.MsgWriter != nil {
+		h.cfg.MsgWriter.WriteMessage(h.client.serverName(), params.Type, params.Message)
+		return nil
+	}
 	log.Printf("LSP %v: %v\n", params.Type, params.Message)
 	return nil
 }
 
 func (h *clientHandler) LogMessage(ctx context.Context, params *protocol.LogMessageParams) error {
+	if h.cfg.MsgWriter != nil {
+		h.cfg.MsgWriter.WriteMessage(h.client.serverName(), params.Type, params.Message)
+	}
 	if h.cfg.Logger != nil {
 		h.cfg.Logger.Printf("%v: %v\n", params.Type, params.Message)
 		return nil
 	}
-	if params.Type == protocol.Error || params.Type == protocol.Warning || Verbose {
+	if h.cfg.MsgWriter == nil && (params.Type == protocol.Error || params.Type == protocol.Warning || Verbose) {
 		log.Printf("log: LSP %v: %v\n", params.Type, params.Message)
 	}
 	return nil
@@ -52,6 +68,7 @@ func (h *clientHandler) Event(context.Context, *interface{}) error {
 }
 
 func (h *clientHandler) PublishDiagnostics(ctx context.Context, params *protocol.PublishDiagnosticsParams) error {
+	h.client.setDiagnostics(params.URI, params.Diagnostics)
 	if h.hideDiag {
 		return nil
 	}
@@ -60,24 +77,56 @@ func (h *clientHandler) PublishDiagnostics(ctx context.Context, params *protocol
 	return nil
 }
 
+// DiagnosticRefresh implements protocol.DiagnosticClient.
+func (h *clientHandler) DiagnosticRefresh(context.Context) error {
+	// Pull in the background because the server may not
+	// respond until we reply to the refresh request.
+	go func() {
+		if err := h.client.refreshDiagnostics(context.Background()); err != nil && Verbose {
+			log.Printf("diagnostics refresh failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Progress implements protocol.ProgressClient.
+func (h *clientHandler) Progress(ctx context.Context, params *protocol.ProgressParams) error {
+	wdp, err := protocol.ToWorkDoneProgress(params.Value)
+	if err != nil {
+		return err
+	}
+	h.client.updateProgress(params.Token, wdp)
+	return nil
+}
+
+// WorkDoneProgressCreate implements protocol.ProgressClient.
+func (h *clientHandler) WorkDoneProgressCreate(context.Context, *protocol.WorkDoneProgressCreateParams) error {
+	return nil
+}
+
 func (h *clientHandler) WorkspaceFolders(context.Context) ([]protocol.WorkspaceFolder, error) {
 	return nil, nil
 }
 
-func (h *clientHandler) Configuration(context.Context, *protocol.ParamConfig) ([]interface{}, error) {
-	return nil, nil
+func (h *clientHandler) Configuration(ctx context.Context, params *protocol.ParamConfig) ([]interface{}, error) {
+	return h.client.configuration(params.Items), nil
 }
 
-func (h *clientHandler) RegisterCapability(context.Context, *protocol.RegistrationParams) error {
-	return nil
+func (h *clientHandler) RegisterCapability(ctx context.Context, params *protocol.RegistrationParams) error {
+	return h.client.register(params.Registrations)
 }
 
-func (h *clientHandler) UnregisterCapability(context.Context, *protocol.UnregistrationParams) error {
+func (h *clientHandler) UnregisterCapability(ctx context.Context, params *protocol.UnregistrationParams) error {
+	h.client.unregister(params.Unregisterations)
 	return nil
 }
 
-func (h *clientHandler) ShowMessageRequest(context.Context, *protocol.ShowMessageRequestParams) (*protocol.MessageActionItem, error) {
-	return nil, nil
+func (h *clientHandler) ShowMessageRequest(ctx context.Context, params *protocol.ShowMessageRequestParams) (*protocol.MessageActionItem, error) {
+	if h.cfg.MsgWriter == nil {
+		// Not running within acme-lsp. Decline instead of bothering the user.
+		return nil, nil
+	}
+	return messageRequest(ctx, h.client.serverName(), params, h.cfg.MessageRequestTimeout, h.cfg.MessageRequestDefault)
 }
 
 func (h *clientHandler) ApplyEdit(ctx context.Context, params *protocol.ApplyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResponse, error) {
@@ -95,9 +144,19 @@ type ClientConfig struct {
 	RootDirectory string                     // used to compute RootURI in initialization
 	HideDiag      bool                       // don't write diagnostics to DiagWriter
 	RPCTrace      bool                       // print LSP rpc trace to stderr
+	WillSave      bool                       // willSave and willSaveWaitUntil are sent before Put
 	DiagWriter    DiagnosticsWriter          // notification handler writes diagnostics here
+	MsgWriter     MessageWriter              // notification handler writes messages here, if not nil
 	Workspaces    []protocol.WorkspaceFolder // initial workspace folders
+	Stderr        *os.File                   // standard error of the server command, if not nil
 	Logger        *log.Logger
+
+	MessageRequestTimeout time.Duration // how long to wait for the user to respond to server requests
+	MessageRequestDefault string        // title of action chosen if the user doesn't respond
+
+	// WatchersChanged is called, if it's not nil, when the file system
+	// watchers registered by the server change.
+	WatchersChanged func()
 }
 
 // Client represents a LSP client connection.
@@ -105,10 +164,47 @@ type Client struct {
 	protocol.Server
 	initializeResult *protocol.InitializeResult
 	cfg              *ClientConfig
+
+	// Capabilities registered dynamically by the server.
+	registrations registry
+
+	// Result IDs of pulled diagnostics, keyed by document URI.
+	diagResultIDs map[protocol.DocumentURI]string
+
+	// Sequence number of the latest pull of diagnostics, keyed by
+	// document URI, so that the results of older pulls that complete
+	// later are dropped.
+	pulls   map[protocol.DocumentURI]uint64
+	pullSeq uint64
+	pullMu  sync.Mutex // held while writing the results of a pull
+
+	// Last diagnostics published or pulled, keyed by document URI.
+	diagnostics map[protocol.DocumentURI][]protocol.Diagnostic
+
+	// Work done progress currently being reported by the server,
+	// keyed by progress token.
+	progress map[string]*proxy.WorkDoneProgressStatus
+
+	// Current workspace folders, used when the server is reinitialized.
+	workspaces []protocol.WorkspaceFolder
+
+	// Current settings returned in workspace/configuration responses.
+	settings      map[string]interface{}
+	scopeSettings map[string]map[string]interface{}
+
+	done chan struct{} // closed when the connection to the server terminates
+	mu   sync.Mutex
 }
 
 func NewClient(conn net.Conn, cfg *ClientConfig) (*Client, error) {
-	c := &Client{cfg: cfg}
+	c := &Client{
+		cfg:        cfg,
+		workspaces: cfg.Workspaces,
+	}
+	if cfg.Server != nil {
+		c.settings = cfg.Settings
+		c.scopeSettings = cfg.ScopeSettings
+	}
 	if err := c.init(conn, cfg); err != nil {
 		return nil, err
 	}
@@ -123,11 +219,17 @@ func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 	}
 	ctx, rpc, server := protocol.NewClient(ctx, stream, &clientHandler{
 		cfg:        cfg,
+		client:     c,
 		hideDiag:   cfg.HideDiag,
 		diagWriter: cfg.DiagWriter,
 		diag:       make(map[protocol.DocumentURI][]protocol.Diagnostic),
 	})
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.done = done
+	c.mu.Unlock()
 	go func() {
+		defer close(done)
 		err := rpc.Run(ctx)
 		if err != nil {
 			log.Printf("connection terminated: %v", err)
@@ -138,26 +240,60 @@ func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
+	workspaces := c.workspaces
+	c.mu.Unlock()
+
 	params := &protocol.InitializeParams{
 		RootURI: text.ToURI(d),
 		Capabilities: protocol.ClientCapabilities{
 			// Workspace: ..., (struct literal)
 			TextDocument: protocol.TextDocumentClientCapabilities{
 				CodeAction: &protocol.CodeActionClientCapabilities{
+					DynamicRegistration:      true,
 					CodeActionLiteralSupport: &protocol.CodeActionLiteralSupport{
 						// CodeActionKind: ..., (struct literal)
 					},
 				},
+				Completion:     &protocol.CompletionClientCapabilities{DynamicRegistration: true},
+				Hover:          &protocol.HoverClientCapabilities{DynamicRegistration: true},
+				SignatureHelp:  &protocol.SignatureHelpClientCapabilities{DynamicRegistration: true},
+				Definition:     &protocol.DefinitionClientCapabilities{DynamicRegistration: true},
+				TypeDefinition: &protocol.TypeDefinitionClientCapabilities{DynamicRegistration: true},
+				Implementation: &protocol.ImplementationClientCapabilities{DynamicRegistration: true},
+				References:     &protocol.ReferenceClientCapabilities{DynamicRegistration: true},
+				Formatting:     &protocol.DocumentFormattingClientCapabilities{DynamicRegistration: true},
+				Rename:         &protocol.RenameClientCapabilities{DynamicRegistration: true},
 				DocumentSymbol: &protocol.DocumentSymbolClientCapabilities{
+					DynamicRegistration:               true,
 					HierarchicalDocumentSymbolSupport: true,
 				},
+				Diagnostic: &protocol.DiagnosticClientCapabilities{
+					DynamicRegistration:    true,
+					RelatedDocumentSupport: true,
+				},
+				Synchronization: &protocol.TextDocumentSyncClientCapabilities{
+					DynamicRegistration: true,
+					WillSave:            cfg.WillSave,
+					WillSaveWaitUntil:   cfg.WillSave,
+					DidSave:             true,
+				},
 			},
 		},
-		WorkspaceFolders:      cfg.Workspaces,
+		WorkspaceFolders:      workspaces,
 		InitializationOptions: cfg.Options,
 	}
+	params.Capabilities.Window = &protocol.WindowClientCapabilities{
+		WorkDoneProgress: true,
+	}
 	params.Capabilities.Workspace.WorkspaceFolders = true
 	params.Capabilities.Workspace.ApplyEdit = true
+	params.Capabilities.Workspace.Configuration = true
+	params.Capabilities.Workspace.ExecuteCommand.DynamicRegistration = true
+	params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration = true
+	params.Capabilities.Workspace.Diagnostics = &protocol.DiagnosticWorkspaceClientCapabilities{
+		RefreshSupport: true,
+	}
 	params.Capabilities.TextDocument.CodeAction.CodeActionLiteralSupport.CodeActionKind.ValueSet =
 		[]protocol.CodeActionKind{protocol.SourceOrganizeImports}
 
@@ -165,17 +301,472 @@ func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 	if err := rpc.Call(ctx, "initialize", params, &result); err != nil {
 		return fmt.Errorf("initialize failed: %v", err)
 	}
+	// Reset the state of the previous connection before the server
+	// can register capabilities, which it may do right after initialized.
+	c.mu.Lock()
+	c.Server = server
+	c.initializeResult = &result
+	c.diagResultIDs = make(map[protocol.DocumentURI]string)
+	c.pulls = make(map[protocol.DocumentURI]uint64)
+	c.diagnostics = nil
+	c.progress = make(map[string]*proxy.WorkDoneProgressStatus)
+	c.registrations = make(registry)
+	settings := c.settings
+	c.mu.Unlock()
+
 	if err := rpc.Notify(ctx, "initialized", &protocol.InitializedParams{}); err != nil {
 		return fmt.Errorf("initialized failed: %v", err)
 	}
-	c.Server = server
-	c.initializeResult = &result
+
+	if len(settings) > 0 {
+		err := server.DidChangeConfiguration(ctx, &protocol.DidChangeConfigurationParams{
+			Settings: settings,
+		})
+		if err != nil {
+			return fmt.Errorf("workspace/didChangeConfiguration failed: %v", err)
+		}
+	}
 	return nil
 }
 
-// InitializeResult implements proxy.Server.
-func (c *Client) InitializeResult(context.Context, *protocol.TextDocumentIdentifier) (*protocol.InitializeResult, error) {
-	return c.initializeResult, nil
+// configuration returns the settings requested in a
+// workspace/configuration request.
+func (c *Client) configuration(items []protocol.ConfigurationItem) []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		settings := scopedSettings(c.settings, c.scopeSettings, protocol.DocumentURI(item.ScopeURI))
+		result[i] = settingsSection(settings, item.Section)
+	}
+	return result
+}
+
+// changeSettings replaces the settings of the server, and notifies the
+// server if they have changed.
+func (c *Client) changeSettings(ctx context.Context, settings map[string]interface{}, scopeSettings map[string]map[string]interface{}) error {
+	c.mu.Lock()
+	changed := !reflect.DeepEqual(c.settings, settings) || !reflect.DeepEqual(c.scopeSettings, scopeSettings)
+	c.settings = settings
+	c.scopeSettings = scopeSettings
+	c.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	if settings == nil {
+		// Servers may reject null settings.
+		settings = make(map[string]interface{})
+	}
+	return c.DidChangeConfiguration(ctx, &protocol.DidChangeConfigurationParams{
+		Settings: settings,
+	})
+}
+
+// willSave tells the server that file name, which is being edited in f,
+// is about to be saved, if the server asks for it. The edits returned
+// by the server for willSaveWaitUntil are applied to f. It returns true
+// if f was changed.
+func (c *Client) willSave(ctx context.Context, name string, f text.File) (bool, error) {
+	params := &protocol.WillSaveTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: text.ToURI(name),
+		},
+		Reason: protocol.Manual,
+	}
+	cap := c.capabilities(params.TextDocument.URI)
+	if lsp.ServerProvides(cap, "textDocument/willSave") {
+		if err := c.WillSave(ctx, params); err != nil {
+			return false, err
+		}
+	}
+	if !lsp.ServerProvides(cap, "textDocument/willSaveWaitUntil") {
+		return false, nil
+	}
+	// Don't let a slow server hold up the Put for long.
+	ctx, cancel := context.WithTimeout(ctx, willSaveTimeout)
+	defer cancel()
+	edits, err := c.WillSaveWaitUntil(ctx, params)
+	if err != nil {
+		return false, err
+	}
+	if len(edits) == 0 {
+		return false, nil
+	}
+	if err := text.Edit(f, edits); err != nil {
+		return false, fmt.Errorf("failed to apply edits: %v", err)
+	}
+	return true, nil
+}
+
+// disconnected returns a channel that's closed when the current
+// connection to the server terminates.
+func (c *Client) disconnected() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// report writes a message about the state of the server (e.g. it was
+// restarted) to MsgWriter, or logs it if MsgWriter is nil.
+func (c *Client) report(typ protocol.MessageType, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if c.cfg.MsgWriter != nil {
+		c.cfg.MsgWriter.WriteMessage(c.serverName(), typ, msg)
+		return
+	}
+	log.Printf("language server %v: %v", c.serverName(), msg)
+}
+
+// setWorkspaces sets the workspace folders sent to the server
+// when it's reinitialized after a restart.
+func (c *Client) setWorkspaces(folders []protocol.WorkspaceFolder) {
+	c.mu.Lock()
+	c.workspaces = folders
+	c.mu.Unlock()
+}
+
+// pullDiagnostics requests diagnostics for the document uri from servers
+// that support pull model diagnostics and writes them to DiagWriter.
+// It does nothing for servers that only publish diagnostics. The results
+// are dropped if diagnostics are pulled again for the document, or it's
+// closed, before they arrive.
+func (c *Client) pullDiagnostics(ctx context.Context, uri protocol.DocumentURI) error {
+	opt := lsp.ServerDiagnosticOptions(c.capabilities(uri))
+	if opt == nil || c.cfg.HideDiag {
+		return nil
+	}
+	server, _ := c.connection()
+	ds, ok := server.(protocol.DiagnosticServer)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	prev := c.diagResultIDs[uri]
+	c.pullSeq++
+	seq := c.pullSeq
+	if c.pulls == nil {
+		c.pulls = make(map[protocol.DocumentURI]uint64)
+	}
+	c.pulls[uri] = seq
+	c.mu.Unlock()
+
+	report, err := ds.Diagnostic(ctx, &protocol.DocumentDiagnosticParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		Identifier:       opt.Identifier,
+		PreviousResultID: prev,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.pullMu.Lock()
+	defer c.pullMu.Unlock()
+
+	c.mu.Lock()
+	latest := c.pulls[uri] == seq
+	c.mu.Unlock()
+	if !latest {
+		return nil // document changed or closed since the pull started
+	}
+	c.writeDiagnosticReport(uri, report)
+	for u := range report.RelatedDocuments {
+		r := report.RelatedDocuments[u]
+		c.writeDiagnosticReport(u, &r)
+	}
+	return nil
+}
+
+// refreshDiagnostics pulls diagnostics again for all the documents
+// we have previously pulled diagnostics for.
+func (c *Client) refreshDiagnostics(ctx context.Context) error {
+	if c.cfg.HideDiag {
+		return nil
+	}
+	// The server may only support pull model diagnostics for some
+	// documents, which is checked for each document by pullDiagnostics.
+	opt := lsp.ServerDiagnosticOptions(c.capabilities(""))
+	c.mu.Lock()
+	var (
+		uris []protocol.DocumentURI
+		prev []protocol.PreviousResultID
+	)
+	for uri, id := range c.diagResultIDs {
+		uris = append(uris, uri)
+		if id != "" {
+			prev = append(prev, protocol.PreviousResultID{
+				URI:   uri,
+				Value: id,
+			})
+		}
+	}
+	c.mu.Unlock()
+
+	server, _ := c.connection()
+	ds, ok := server.(protocol.DiagnosticServer)
+	if opt != nil && opt.WorkspaceDiagnostics && ok {
+		report, err := ds.WorkspaceDiagnostic(ctx, &protocol.WorkspaceDiagnosticParams{
+			Identifier:        opt.Identifier,
+			PreviousResultIds: prev,
+		})
+		if err != nil {
+			return err
+		}
+		for i := range report.Items {
+			r := &report.Items[i]
+			c.writeDiagnosticReport(r.URI, &r.DocumentDiagnosticReport)
+		}
+		return nil
+	}
+	for _, uri := range uris {
+		if err := c.pullDiagnostics(ctx, uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) writeDiagnosticReport(uri protocol.DocumentURI, report *protocol.DocumentDiagnosticReport) {
+	c.mu.Lock()
+	c.diagResultIDs[uri] = report.ResultID
+	c.mu.Unlock()
+
+	if report.Kind != protocol.DiagnosticFull {
+		return // unchanged since last report
+	}
+	c.setDiagnostics(uri, report.Items)
+	c.cfg.DiagWriter.WriteDiagnostics(&protocol.PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: report.Items,
+	})
+}
+
+// forgetDiagnostics stops tracking pulled diagnostics for the document uri.
+func (c *Client) forgetDiagnostics(uri protocol.DocumentURI) {
+	c.mu.Lock()
+	delete(c.diagResultIDs, uri)
+	delete(c.pulls, uri)
+	delete(c.diagnostics, uri)
+	c.mu.Unlock()
+}
+
+// setDiagnostics records the diagnostics of document uri.
+func (c *Client) setDiagnostics(uri protocol.DocumentURI, diags []protocol.Diagnostic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(diags) == 0 {
+		delete(c.diagnostics, uri)
+		return
+	}
+	if c.diagnostics == nil {
+		c.diagnostics = make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	}
+	c.diagnostics[uri] = diags
+}
+
+// errorDiagnostic returns the first diagnostic of error severity
+// for document uri whose source is one of sources, or nil if there is
+// no such diagnostic. The source "*" matches any source.
+func (c *Client) errorDiagnostic(uri protocol.DocumentURI, sources []string) *protocol.Diagnostic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, d := range c.diagnostics[uri] {
+		if d.Severity != protocol.SeverityError {
+			continue
+		}
+		for _, s := range sources {
+			if s == "*" || s == d.Source {
+				return &c.diagnostics[uri][i]
+			}
+		}
+	}
+	return nil
+}
+
+// updateProgress records work done progress wdp reported by the server
+// for the given token. The beginning and end of the progress is written
+// to MsgWriter regardless of its message level. Intermediate reports,
+// which can be frequent, are written as Log messages.
+func (c *Client) updateProgress(token protocol.ProgressToken, wdp *protocol.WorkDoneProgress) {
+	key := fmt.Sprint(token)
+	name := c.serverName()
+
+	c.mu.Lock()
+	s, ok := c.progress[key]
+	switch wdp.Kind {
+	case protocol.ProgressBegin:
+		s = &proxy.WorkDoneProgressStatus{
+			Server:     name,
+			Title:      wdp.Title,
+			Message:    wdp.Message,
+			Percentage: wdp.Percentage,
+			Start:      time.Now(),
+		}
+		c.progress[key] = s
+	case protocol.ProgressReport:
+		if !ok {
+			c.mu.Unlock()
+			return
+		}
+		if wdp.Message != "" {
+			s.Message = wdp.Message
+		}
+		if wdp.Percentage > 0 {
+			s.Percentage = wdp.Percentage
+		}
+	case protocol.ProgressEnd:
+		if !ok {
+			c.mu.Unlock()
+			return
+		}
+		delete(c.progress, key)
+		s.Message = wdp.Message
+		s.Percentage = 0
+	default:
+		c.mu.Unlock()
+		return // partial result
+	}
+	msg := s.String()
+	c.mu.Unlock()
+
+	if c.cfg.MsgWriter == nil {
+		if Verbose {
+			log.Printf("LSP progress %v: %v", wdp.Kind, msg)
+		}
+		return
+	}
+	switch wdp.Kind {
+	case protocol.ProgressBegin:
+		c.cfg.MsgWriter.WriteProgress(name, msg)
+	case protocol.ProgressReport:
+		c.cfg.MsgWriter.WriteMessage(name, protocol.Log, msg)
+	case protocol.ProgressEnd:
+		c.cfg.MsgWriter.WriteProgress(name, fmt.Sprintf("%v (done)", msg))
+	}
+}
+
+// WorkDoneProgress implements proxy.Server.
+// It returns the progress being reported, oldest first.
+func (c *Client) WorkDoneProgress(context.Context) ([]proxy.WorkDoneProgressStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var result []proxy.WorkDoneProgressStatus
+	for _, s := range c.progress {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result, nil
+}
+
+// serverName returns the name of the server as reported in initialization,
+// or the command name or address used to connect to the server.
+func (c *Client) serverName() string {
+	if _, r := c.connection(); r != nil && r.ServerInfo != nil && r.ServerInfo.Name != "" {
+		return r.ServerInfo.Name
+	}
+	if len(c.cfg.Command) > 0 {
+		return filepath.Base(c.cfg.Command[0])
+	}
+	return c.cfg.Address
+}
+
+// InitializeResult implements proxy.Server. The capabilities in the
+// result include the ones registered dynamically for the document.
+func (c *Client) InitializeResult(ctx context.Context, doc *protocol.TextDocumentIdentifier) (*protocol.InitializeResult, error) {
+	_, result := c.connection()
+	if doc == nil || result == nil {
+		return result, nil
+	}
+	r := *result
+	r.Capabilities = *c.capabilities(doc.URI)
+	return &r, nil
+}
+
+// connection returns the server of the current connection and the
+// result of its initialization. They change when the server is
+// restarted or reconnected.
+func (c *Client) connection() (protocol.Server, *protocol.InitializeResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Server, c.initializeResult
+}
+
+// capabilities returns the capabilities of the server for document uri,
+// including the ones registered dynamically. If uri is empty, only the
+// capabilities that aren't registered for some documents are included.
+func (c *Client) capabilities(uri protocol.DocumentURI) *protocol.ServerCapabilities {
+	var languageID string
+	if c.cfg != nil && c.cfg.FilenameHandler != nil {
+		languageID = c.cfg.FilenameHandler.LanguageID
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var cap protocol.ServerCapabilities
+	if c.initializeResult != nil {
+		cap = c.initializeResult.Capabilities
+	}
+	return c.registrations.capabilities(&cap, uri, languageID)
+}
+
+// register adds the capabilities registered dynamically by the server.
+func (c *Client) register(regs []protocol.Registration) error {
+	watchers := false
+	defer func() {
+		if watchers {
+			c.watchersChanged()
+		}
+	}()
+	var name string
+	if Verbose {
+		name = c.serverName() // can't be called with c.mu held
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.registrations == nil {
+		c.registrations = make(registry)
+	}
+	for i := range regs {
+		if err := c.registrations.register(&regs[i]); err != nil {
+			return err
+		}
+		if Verbose {
+			log.Printf("language server %v registered %v", name, regs[i].Method)
+		}
+		watchers = watchers || regs[i].Method == "workspace/didChangeWatchedFiles"
+	}
+	return nil
+}
+
+// unregister removes capabilities registered dynamically by the server.
+func (c *Client) unregister(unregs []protocol.Unregistration) {
+	watchers := false
+	c.mu.Lock()
+	for _, u := range unregs {
+		c.registrations.unregister(u.ID, u.Method)
+		watchers = watchers || u.Method == "workspace/didChangeWatchedFiles"
+	}
+	c.mu.Unlock()
+
+	if watchers {
+		c.watchersChanged()
+	}
+}
+
+func (c *Client) watchersChanged() {
+	if c.cfg != nil && c.cfg.WatchersChanged != nil {
+		c.cfg.WatchersChanged()
+	}
 }
 
 // Version exists only to implement proxy.Server.
@@ -188,6 +779,26 @@ func (c *Client) WorkspaceFolders(context.Context) ([]protocol.WorkspaceFolder,
 	panic("intentionally not implemented")
 }
 
+// Servers exists only to implement proxy.Server.
+func (c *Client) Servers(context.Context) ([]proxy.ServerStatus, error) {
+	panic("intentionally not implemented")
+}
+
+// RestartServer exists only to implement proxy.Server.
+func (c *Client) RestartServer(context.Context, *proxy.ServerKeyParams) error {
+	panic("intentionally not implemented")
+}
+
+// StopServer exists only to implement proxy.Server.
+func (c *Client) StopServer(context.Context, *proxy.ServerKeyParams) error {
+	panic("intentionally not implemented")
+}
+
+// Reload exists only to implement proxy.Server.
+func (c *Client) Reload(context.Context) error {
+	panic("intentionally not implemented")
+}
+
 // ExecuteCommandOnDocument implements proxy.Server.
 func (s *Client) ExecuteCommandOnDocument(ctx context.Context, params *proxy.ExecuteCommandOnDocumentParams) (interface{}, error) {
 	return s.Server.ExecuteCommand(ctx, &params.ExecuteCommandParams)
@@ -3,6 +3,7 @@ package acmelsp
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -10,11 +11,13 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/fhs/acme-lsp/internal/lsp"
 	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
 	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/fhs/acme-lsp/internal/lsp/proxy"
 	"github.com/fhs/acme-lsp/internal/lsp/text"
 )
 
@@ -74,7 +77,7 @@ func testGoModule(t *testing.T, server string, src string, f func(t *testing.T,
 		RootDirectory: dir,
 		DiagWriter:    &mockDiagosticsWriter{ioutil.Discard},
 		Workspaces:    nil,
-	})
+	}, nil)
 	if err != nil {
 		t.Fatalf("startServer failed: %v", err)
 	}
@@ -302,7 +305,7 @@ func main() {
 		RootDirectory: dir,
 		DiagWriter:    &chanDiagosticsWriter{ch},
 		Workspaces:    nil,
-	})
+	}, nil)
 	if err != nil {
 		t.Fatalf("startServer failed: %v", err)
 	}
@@ -371,7 +374,7 @@ func testPython(t *testing.T, src string, f func(t *testing.T, c *Client, uri pr
 		RootDirectory: dir,
 		DiagWriter:    &mockDiagosticsWriter{ioutil.Discard},
 		Workspaces:    nil,
-	})
+	}, nil)
 	if err != nil {
 		t.Fatalf("startServer failed: %v", err)
 	}
@@ -549,6 +552,8 @@ func (dw *chanDiagosticsWriter) WriteDiagnostics(params *protocol.PublishDiagnos
 	}
 }
 
+func (dw *chanDiagosticsWriter) DropDiagnostics(uri protocol.DocumentURI) {}
+
 var _ = text.File((*BytesFile)(nil))
 
 type BytesFile []byte
@@ -616,3 +621,169 @@ func TestClientProvidesCodeAction(t *testing.T) {
 		}
 	}
 }
+
+type willSaveServer struct {
+	protocol.Server
+	willSave int
+	edits    []protocol.TextEdit
+}
+
+func (s *willSaveServer) WillSave(context.Context, *protocol.WillSaveTextDocumentParams) error {
+	s.willSave++
+	return nil
+}
+
+func (s *willSaveServer) WillSaveWaitUntil(context.Context, *protocol.WillSaveTextDocumentParams) ([]protocol.TextEdit, error) {
+	return s.edits, nil
+}
+
+func TestClientWillSave(t *testing.T) {
+	edits := []protocol.TextEdit{
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 0, Character: 0},
+				End:   protocol.Position{Line: 0, Character: 5},
+			},
+			NewText: "world",
+		},
+	}
+	for _, tc := range []struct {
+		sync     interface{}
+		willSave int
+		changed  bool
+		want     string
+	}{
+		{1.0, 0, false, "hello\n"},
+		{map[string]interface{}{"willSave": true}, 1, false, "hello\n"},
+		{map[string]interface{}{"willSaveWaitUntil": true}, 0, true, "world\n"},
+	} {
+		srv := &willSaveServer{edits: edits}
+		c := &Client{
+			Server: srv,
+			initializeResult: &protocol.InitializeResult{
+				Capabilities: protocol.ServerCapabilities{
+					TextDocumentSync: tc.sync,
+				},
+			},
+		}
+		f := BytesFile("hello\n")
+		changed, err := c.willSave(context.Background(), "/a/main.go", &f)
+		if err != nil {
+			t.Fatalf("willSave failed: %v", err)
+		}
+		if changed != tc.changed || srv.willSave != tc.willSave || string(f) != tc.want {
+			t.Errorf("willSave with sync options %v: changed=%v willSave=%v body=%q; want %v, %v, %q",
+				tc.sync, changed, srv.willSave, f, tc.changed, tc.willSave, tc.want)
+		}
+	}
+}
+
+type pullServer struct {
+	protocol.Server
+	started chan string              // receives the result ID of each request
+	release map[string]chan struct{} // closed to complete the request with the result ID
+	n       int                      // number of requests
+}
+
+func (s *pullServer) Diagnostic(ctx context.Context, params *protocol.DocumentDiagnosticParams) (*protocol.DocumentDiagnosticReport, error) {
+	id := fmt.Sprint(s.n)
+	s.n++
+	s.started <- id
+	<-s.release[id]
+	return &protocol.DocumentDiagnosticReport{
+		Kind:     protocol.DiagnosticFull,
+		ResultID: id,
+		Items:    []protocol.Diagnostic{{Message: "pull " + id}},
+	}, nil
+}
+
+func (s *pullServer) WorkspaceDiagnostic(context.Context, *protocol.WorkspaceDiagnosticParams) (*protocol.WorkspaceDiagnosticReport, error) {
+	return &protocol.WorkspaceDiagnosticReport{}, nil
+}
+
+type diagRecorder struct {
+	mu    sync.Mutex
+	diags []protocol.Diagnostic // last diagnostics written
+}
+
+func (r *diagRecorder) WriteDiagnostics(params *protocol.PublishDiagnosticsParams) {
+	r.mu.Lock()
+	r.diags = params.Diagnostics
+	r.mu.Unlock()
+}
+
+func (r *diagRecorder) DropDiagnostics(protocol.DocumentURI) {}
+
+func TestClientPullDiagnosticsOrder(t *testing.T) {
+	srv := &pullServer{
+		started: make(chan string, 2),
+		release: map[string]chan struct{}{
+			"0": make(chan struct{}),
+			"1": make(chan struct{}),
+		},
+	}
+	rec := &diagRecorder{}
+	c := &Client{
+		Server: srv,
+		cfg:    &ClientConfig{DiagWriter: rec},
+		initializeResult: &protocol.InitializeResult{
+			Capabilities: protocol.ServerCapabilities{
+				DiagnosticProvider: map[string]interface{}{},
+			},
+		},
+		diagResultIDs: make(map[protocol.DocumentURI]string),
+	}
+	uri := text.ToURI("/a/main.go")
+	pull := func() <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- c.pullDiagnostics(context.Background(), uri)
+		}()
+		<-srv.started
+		return done
+	}
+	older := pull()
+	newer := pull()
+
+	// The response to the older pull arrives last.
+	close(srv.release["1"])
+	if err := <-newer; err != nil {
+		t.Fatalf("pullDiagnostics failed: %v", err)
+	}
+	close(srv.release["0"])
+	if err := <-older; err != nil {
+		t.Fatalf("pullDiagnostics failed: %v", err)
+	}
+	if len(rec.diags) != 1 || rec.diags[0].Message != "pull 1" {
+		t.Errorf("diagnostics are %v; want the ones from the newer pull", rec.diags)
+	}
+}
+
+func TestClientProgressMessages(t *testing.T) {
+	mw := newMsgWin("/LSP/Messages", protocol.Warning) // the default level
+	c := &Client{
+		cfg: &ClientConfig{
+			Server:    &config.Server{Command: []string{"gopls"}},
+			MsgWriter: mw,
+		},
+		progress: make(map[string]*proxy.WorkDoneProgressStatus),
+	}
+	c.updateProgress("x", &protocol.WorkDoneProgress{Kind: protocol.ProgressBegin, Title: "Loading packages"})
+	c.updateProgress("x", &protocol.WorkDoneProgress{Kind: protocol.ProgressReport, Percentage: 40})
+	c.updateProgress("x", &protocol.WorkDoneProgress{Kind: protocol.ProgressEnd, Message: "Finished"})
+
+	lines := mw.takePending()
+	want := []string{
+		"gopls Progress: Loading packages\n",
+		"gopls Progress: Loading packages: Finished (done)\n",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("pending lines are %q; want %q", lines, want)
+	}
+	for i, l := range lines {
+		// Skip the date and time.
+		if f := strings.SplitN(l, " ", 3); len(f) != 3 || f[2] != want[i] {
+			t.Errorf("line %v is %q; want it to end with %q", i, l, want[i])
+		}
+	}
+}
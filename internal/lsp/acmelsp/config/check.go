@@ -0,0 +1,320 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+)
+
+// Problem is a problem found in a configuration file by Check.
+type Problem struct {
+	Filename string
+	Line     int // line number, or 0 if unknown
+	Message  string
+}
+
+func (p *Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%v:%v: %v", p.Filename, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%v: %v", p.Filename, p.Message)
+}
+
+// Error implements error. Load returns the problems that prevent it from
+// loading the configuration file as errors.
+func (p *Problem) Error() string {
+	return p.String()
+}
+
+// Check checks the configuration file cfg was loaded from, and the
+// project configuration file of the current directory, if any. It
+// reports problems that would otherwise be silently ignored or only
+// found once a LSP server is started: unknown keys, invalid filename
+// patterns, undefined server keys, server commands not found in $PATH,
+// and unsupported code action kinds. The returned error is only
+// non-nil if a configuration file could not be read or parsed.
+func Check(cfg *Config) ([]Problem, error) {
+	var problems []Problem
+	if cfg.filename != "" {
+		var f File
+		c, err := newChecker(cfg.filename, &f)
+		if err != nil {
+			return nil, err
+		}
+		c.checkFile(&f)
+		problems = append(problems, c.problems...)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	p, err := FindProject(dir)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		var pf Project
+		c, err := newChecker(filepath.Join(p.Dir, ProjectFilename), &pf)
+		if err != nil {
+			return nil, err
+		}
+		c.checkProject(&pf, cfg)
+		problems = append(problems, c.problems...)
+	}
+	return problems, nil
+}
+
+// checker checks a configuration file.
+type checker struct {
+	filename string
+	md       toml.MetaData
+	pos      *keyPositions
+	problems []Problem
+}
+
+func newChecker(filename string, v interface{}) (*checker, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	md, err := toml.Decode(string(b), v)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return &checker{
+		filename: filename,
+		md:       md,
+		pos:      findKeyPositions(b),
+	}, nil
+}
+
+func (c *checker) errorf(line int, format string, v ...interface{}) {
+	c.problems = append(c.problems, Problem{
+		Filename: c.filename,
+		Line:     line,
+		Message:  fmt.Sprintf(format, v...),
+	})
+}
+
+// checkUndecoded reports keys in the file that don't correspond to
+// any configuration option.
+func (c *checker) checkUndecoded() {
+	undecoded := make(map[string]bool)
+	for _, key := range c.md.Undecoded() {
+		undecoded[key.String()] = true
+	}
+	for _, key := range c.md.Undecoded() {
+		if len(key) >= 3 && key[0] == "Servers" {
+			switch key[2] {
+			case "Options", "Settings", "ScopeSettings":
+				continue // passed as-is to the server
+			}
+		}
+		if len(key) > 1 && undecoded[key[:len(key)-1].String()] {
+			continue // already reported the unknown table
+		}
+		c.errorf(c.pos.key(key), "unknown configuration key %q", key.String())
+	}
+}
+
+func (c *checker) checkFile(f *File) {
+	c.checkUndecoded()
+	c.checkCodeActions("CodeActionsOnPut", f.CodeActionsOnPut, c.pos.key(toml.Key{"CodeActionsOnPut"}))
+
+	servers := make(map[string]*Server)
+	for key, cs := range f.Servers {
+		servers[key] = cs
+	}
+	for _, name := range f.Presets {
+		p, ok := presets[name]
+		if !ok {
+			c.errorf(c.pos.key(toml.Key{"Presets"}), "unknown preset %q", name)
+			continue
+		}
+		servers[name] = p.server(f.Servers[name])
+	}
+
+	var keys []string
+	for key := range servers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cs := servers[key]
+		if cs == nil {
+			continue
+		}
+		line, ok := c.pos.keys["Servers."+key]
+		if !ok {
+			line = c.pos.key(toml.Key{"Presets"}) // defined only by preset
+		}
+		switch {
+		case len(cs.Command) == 0 && cs.Address == "":
+			c.errorf(line, "server %q has neither Command nor Address", key)
+		case len(cs.Command) > 0:
+			c.checkCommand(key, cs.Command, line)
+		}
+		if cs.CodeActionsOnPut != nil {
+			c.checkCodeActions("CodeActionsOnPut", *cs.CodeActionsOnPut, c.pos.key(toml.Key{"Servers", key, "CodeActionsOnPut"}))
+		}
+	}
+	c.checkHandlers(f.FilenameHandlers, servers)
+}
+
+func (c *checker) checkProject(p *Project, cfg *Config) {
+	c.checkUndecoded()
+	if p.CodeActionsOnPut != nil {
+		c.checkCodeActions("CodeActionsOnPut", *p.CodeActionsOnPut, c.pos.key(toml.Key{"CodeActionsOnPut"}))
+	}
+	for key := range p.Servers {
+		if _, ok := cfg.Servers[key]; !ok {
+			c.errorf(c.pos.key(toml.Key{"Servers", key}), "server %q is not defined in the user configuration", key)
+		}
+	}
+	c.checkHandlers(p.FilenameHandlers, cfg.Servers)
+}
+
+// checkCommand reports if the command of server key isn't found.
+// The server is defined at the given line.
+func (c *checker) checkCommand(key string, command []string, line int) {
+	name := command[0]
+	if strings.Contains(name, "$ROOT") || strings.Contains(name, "${ROOT}") {
+		return // depends on the project root
+	}
+	name = os.ExpandEnv(name)
+	if _, err := exec.LookPath(name); err != nil {
+		if l, ok := c.pos.keys["Servers."+key+".Command"]; ok {
+			line = l
+		}
+		c.errorf(line, "command of server %q not found: %v", key, err)
+	}
+}
+
+func (c *checker) checkHandlers(handlers []FilenameHandler, servers map[string]*Server) {
+	for i, h := range handlers {
+		if h.CodeActionsOnPut != nil {
+			c.checkCodeActions("CodeActionsOnPut", *h.CodeActionsOnPut, c.pos.arrayKey("FilenameHandlers", i, "CodeActionsOnPut"))
+		}
+		if _, err := regexp.Compile(h.Pattern); err != nil {
+			c.errorf(c.pos.arrayKey("FilenameHandlers", i, "Pattern"), "invalid Pattern: %v", err)
+		}
+		if _, ok := servers[h.ServerKey]; !ok {
+			c.errorf(c.pos.arrayKey("FilenameHandlers", i, "ServerKey"), "server %q is not defined", h.ServerKey)
+		}
+	}
+}
+
+// checkCodeActions reports code action kinds that aren't within the
+// kinds defined by LSP. The kinds are defined in option at line.
+func (c *checker) checkCodeActions(option string, kinds []protocol.CodeActionKind, line int) {
+	for _, k := range kinds {
+		base := strings.SplitN(string(k), ".", 2)[0]
+		switch protocol.CodeActionKind(base) {
+		case protocol.QuickFix, protocol.Refactor, protocol.Source:
+		default:
+			c.errorf(line, "unsupported code action kind %q in %v", k, option)
+		}
+	}
+}
+
+// keyPositions holds the line numbers of keys in a TOML file.
+// The TOML package doesn't provide them, so they're found by a simple
+// scan of the file, which is good enough for error messages.
+type keyPositions struct {
+	keys   map[string]int              // first definition of key, keyed by dotted path
+	arrays map[string][]map[string]int // keys in array of tables elements, keyed by array path and key
+}
+
+func findKeyPositions(b []byte) *keyPositions {
+	pos := &keyPositions{
+		keys:   make(map[string]int),
+		arrays: make(map[string][]map[string]int),
+	}
+	var (
+		table string
+		elem  map[string]int // keys of current array of tables element
+	)
+	record := func(key string, line int) {
+		if _, ok := pos.keys[key]; !ok {
+			pos.keys[key] = line
+		}
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		l := strings.TrimSpace(s.Text())
+		switch {
+		case l == "" || l[0] == '#':
+		case strings.HasPrefix(l, "[["):
+			table = unquoteKey(strings.SplitN(l[2:], "]]", 2)[0])
+			elem = map[string]int{"": line}
+			pos.arrays[table] = append(pos.arrays[table], elem)
+			record(table, line)
+		case l[0] == '[':
+			table = unquoteKey(strings.SplitN(l[1:], "]", 2)[0])
+			elem = nil
+			record(table, line)
+		default:
+			i := strings.Index(l, "=")
+			if i < 0 {
+				continue
+			}
+			key := unquoteKey(l[:i])
+			if elem != nil {
+				if _, ok := elem[key]; !ok {
+					elem[key] = line
+				}
+			}
+			if table != "" {
+				key = table + "." + key
+			}
+			record(key, line)
+		}
+	}
+	return pos
+}
+
+// unquoteKey removes spaces and quotes from a TOML key. It doesn't
+// handle dots within quotes.
+func unquoteKey(k string) string {
+	var parts []string
+	for _, p := range strings.Split(k, ".") {
+		parts = append(parts, strings.Trim(strings.TrimSpace(p), `"'`))
+	}
+	return strings.Join(parts, ".")
+}
+
+// key returns the line number of key, or the line number of the
+// closest ancestor if key isn't found.
+func (pos *keyPositions) key(key toml.Key) int {
+	for n := len(key); n > 0; n-- {
+		if line, ok := pos.keys[strings.Join(key[:n], ".")]; ok {
+			return line
+		}
+	}
+	return 0
+}
+
+// arrayKey returns the line number of key in the i-th element of the
+// array of tables named array, or the line number of the element if
+// key isn't found.
+func (pos *keyPositions) arrayKey(array string, i int, key string) int {
+	elems := pos.arrays[array]
+	if i >= len(elems) {
+		return 0
+	}
+	if line, ok := elems[i][key]; ok {
+		return line
+	}
+	return elems[i][""]
+}
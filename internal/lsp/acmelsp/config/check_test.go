@@ -0,0 +1,102 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testCheckConfig = `FormatOnSave = true
+CodeActionsOnPut = ["source.organizeImports", "organize"]
+
+[Servers]
+	[Servers.gopls]
+	Comand = ["gopls"]
+	Command = ["acme-lsp-test-nonexistent", "serve"]
+
+		[Servers.gopls.Options]
+		hoverKind = "FullDocumentation"
+
+	[Servers.pyls]
+	Address = "unix:/tmp/pyls.sock"
+
+[[FilenameHandlers]]
+  Pattern = "\\.go$"
+  ServerKey = "gopls"
+
+[[FilenameHandlers]]
+  Pattern = "(\\.py$"
+  ServerKey = "pyls"
+
+[[FilenameHandlers]]
+  Pattern = "\\.rs$"
+  LangID = "rust"
+  ServerKey = "rls"
+`
+
+func TestCheckFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-lsp-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(filename, []byte(testCheckConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var f File
+	c, err := newChecker(filename, &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkFile(&f)
+
+	var got []int
+	for _, p := range c.problems {
+		got = append(got, p.Line)
+	}
+	want := []int{
+		1,  // FormatOnSave
+		6,  // Servers.gopls.Comand
+		25, // FilenameHandlers.LangID
+		2,  // organize
+		7,  // acme-lsp-test-nonexistent
+		20, // (\.py$
+		26, // rls
+	}
+	if !cmp.Equal(got, want) {
+		for _, p := range c.problems {
+			t.Logf("%v", &p)
+		}
+		t.Errorf("problems found at lines %v; want %v", got, want)
+	}
+}
+
+func TestFindKeyPositions(t *testing.T) {
+	pos := findKeyPositions([]byte(testCheckConfig))
+	for _, tc := range []struct {
+		key  []string
+		line int
+	}{
+		{[]string{"CodeActionsOnPut"}, 2},
+		{[]string{"Servers", "gopls"}, 5},
+		{[]string{"Servers", "gopls", "Command"}, 7},
+		{[]string{"Servers", "gopls", "Options", "hoverKind"}, 10},
+		{[]string{"Servers", "pyls", "Args"}, 12},
+		{[]string{"NoSuchKey"}, 0},
+	} {
+		if line := pos.key(tc.key); line != tc.line {
+			t.Errorf("line of key %v is %v; want %v", tc.key, line, tc.line)
+		}
+	}
+	if line := pos.arrayKey("FilenameHandlers", 2, "ServerKey"); line != 26 {
+		t.Errorf("line of FilenameHandlers[2].ServerKey is %v; want 26", line)
+	}
+	if line := pos.arrayKey("FilenameHandlers", 0, "LanguageID"); line != 15 {
+		t.Errorf("line of FilenameHandlers[0] is %v; want 15", line)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/fhs/9fans-go/plan9/client"
@@ -47,15 +48,73 @@ type File struct {
 	// Don't show diagnostics sent by the LSP server.
 	HideDiagnostics bool
 
-	// Format file when Put is executed in a window.
+	// Minimum time between updates of the diagnostics window.
+	// Diagnostics received in between updates are coalesced per file.
+	DiagnosticsUpdateInterval Duration
+
+	// Minimum type of messages sent by the LSP server (window/showMessage
+	// and window/logMessage notifications) that are shown in the
+	// "/LSP/Messages" window. One of "Error", "Warning", "Info" or "Log".
+	// The beginning and end of work done progress are always shown.
+	MessageLevel string
+
+	// Maximum time to wait for the user to choose an action requested
+	// by the LSP server (window/showMessageRequest). Defaults to 5 minutes.
+	MessageRequestTimeout Duration
+
+	// Title of the action chosen when the user doesn't choose one of the
+	// actions requested by the LSP server before MessageRequestTimeout, or
+	// deletes the request window. If it's empty or doesn't match any of the
+	// actions, the request is declined.
+	MessageRequestDefault string
+
+	// Format file when Put is executed in a window. It can be overridden
+	// for the files handled by a server or a filename handler.
 	FormatOnPut bool
 
 	// Print to stderr the full rpc trace in lsp inspector format
 	RPCTrace bool
 
-	// LSP code actions to run when Put is executed in a window.
+	// LSP code actions to run when Put is executed in a window, if
+	// the file is formatted. It can be overridden for the files handled
+	// by a server or a filename handler.
 	CodeActionsOnPut []protocol.CodeActionKind
 
+	// Don't format a file when Put is executed if the LSP server has
+	// reported diagnostics of error severity for it from one of these
+	// sources (e.g. "syntax" for parse errors reported by gopls), since
+	// formatting code that doesn't parse may mangle it. The source "*"
+	// matches any source. The diagnostics are the last ones reported,
+	// which may not reflect the latest changes. Defaults to ["syntax"].
+	SkipFormatOnErrors []string
+
+	// Maximum fraction (e.g. 0.5) of the lines of a file that formatting
+	// a file when Put is executed may change. If the formatting edits
+	// change more lines, and more than 10 lines, they're not applied
+	// and the diff is shown in the "/LSP/Messages" window instead.
+	// Defaults to 0.5. Setting it to 1 allows any change.
+	MaxFormatChange float64
+
+	// Handle Put executed in the windows of files handled by a LSP
+	// server, so that the server is sent willSave notifications and
+	// willSaveWaitUntil requests, and the file is formatted, before
+	// the file is written. Otherwise, the file is formatted after it's
+	// written and has to be Put again. This requires opening the event
+	// file of the windows, which fails if another program (e.g. win) is
+	// using it, in which case the window is formatted after Put. Other
+	// commands executed in the windows are passed back to acme, except
+	// that the argument of a 2-1 chord is lost. Changes only apply to
+	// windows opened after the configuration is reloaded.
+	InterceptPut bool
+
+	// Reload the configuration file when it changes, as if "L reload"
+	// was executed.
+	ReloadOnChange bool
+
+	// Names of predefined server configurations (e.g. "gopls" or
+	// "clangd") added to Servers and FilenameHandlers. See Preset.
+	Presets []string
+
 	// LSP servers keyed by a user provided name.
 	Servers map[string]*Server
 
@@ -71,11 +130,20 @@ type Config struct {
 	// Show current configuration and exit
 	ShowConfig bool
 
+	// Check configuration files for problems and exit
+	CheckConfig bool
+
 	// Print more messages to stderr
 	Verbose bool
 
 	// Path to configuration file.
 	filename string
+
+	// Flags and arguments given to ParseFlags, which are parsed
+	// again by Reload.
+	flags     Flags
+	arguments []string
+	parsed    bool
 }
 
 // Server describes a LSP server.
@@ -85,8 +153,41 @@ type Server struct {
 	Command []string
 
 	// Dial address for LSP server. Ignored if Command is not empty.
+	// The address is either "unix:path" for a unix domain socket,
+	// or "tcp:host:port" or "host:port" for a TCP connection.
+	// The connection is reestablished, following the restart policy
+	// (see MaxRestarts), if it's lost.
 	Address string
 
+	// Shared makes Command a long-lived daemon shared by all acme-lsp
+	// instances of the user (e.g. one per acme session), which connect
+	// to it over a unix domain socket. The daemon is started if it's not
+	// already running, and it's left running when acme-lsp exits.
+	// $SOCKET in Command is replaced with the path of the socket the
+	// daemon must listen on. The server must support serving several
+	// clients (e.g. ["gopls", "serve", "-listen=unix;$SOCKET",
+	// "-listen.timeout=1h"]). The daemon isn't shut down by acme-lsp.
+	// Command, Env and Dir can't refer to $ROOT, since the same daemon
+	// is used for all project roots.
+	Shared bool
+
+	// Environment variables (e.g. "GOFLAGS=-tags=integration") added to
+	// the environment of Command.
+	Env []string
+
+	// Working directory of Command. Defaults to the working directory
+	// of acme-lsp.
+	Dir string
+
+	// Root directory used for LSP initialization, instead of the global
+	// RootDirectory.
+	//
+	// Command, Env, Dir and RootDirectory may refer to environment
+	// variables as $VAR or ${VAR}. In Command, Env and Dir, $ROOT refers
+	// to the root directory of the server, and in RootDirectory it refers
+	// to the global RootDirectory.
+	RootDirectory string
+
 	// Write stderr of Command to this file.
 	// If it's not an absolute path, it'll become relative to the cache directory.
 	StderrFile string
@@ -98,6 +199,60 @@ type Server struct {
 
 	// Options contain server-specific settings that are passed as-is to the LSP server.
 	Options interface{}
+
+	// Settings returned to the LSP server in response to
+	// workspace/configuration requests. The requested section (e.g.
+	// "python.analysis") is looked up as a path of nested tables. The
+	// settings are also sent in a workspace/didChangeConfiguration
+	// notification after initialization and whenever they change.
+	Settings map[string]interface{}
+
+	// Settings that override Settings for requests scoped to a file or
+	// directory within a directory, keyed by the directory. The settings
+	// of nested directories are applied from the outermost to innermost.
+	ScopeSettings map[string]map[string]interface{}
+
+	// Maximum number of times Command is restarted within CrashLoopWindow
+	// after it exits unexpectedly. Defaults to 5. If it's negative, the
+	// server is never restarted.
+	MaxRestarts int
+
+	// Time to wait before restarting Command the first time within
+	// CrashLoopWindow. It's doubled for each subsequent restart, up to
+	// a minute. Defaults to 1 second.
+	RestartBackoff Duration
+
+	// Window of time used to detect that Command is crashing repeatedly.
+	// Defaults to 1 minute.
+	CrashLoopWindow Duration
+
+	// Files or directories (e.g. "go.mod" or "pyproject.toml") that mark
+	// the project root of a file. If it's not empty, a separate instance
+	// of the server is started for each project root, which is used as
+	// its root URI and only workspace folder. Files not within a project
+	// root are handled by an instance using RootDirectory and
+	// WorkspaceDirectories.
+	RootMarkers []string
+
+	// Priority of the server when a file is handled by more than one server.
+	// All the servers are told about the file, but each request is sent to
+	// the server with the highest priority that supports the request. Servers
+	// with equal priority are ordered as in FilenameHandlers.
+	Priority int
+
+	// Shut down the server after it has had no open files and no
+	// requests for this long. It's started again when it's needed.
+	// Zero means the server is never shut down for being idle.
+	IdleTimeout Duration
+
+	// Overrides the global FormatOnPut for files handled by the
+	// server, if set.
+	FormatOnPut *bool
+
+	// Overrides the global CodeActionsOnPut for files handled by the
+	// server, if set (e.g. to run "source.fixAll" only for one language,
+	// or to not send code action kinds the server rejects).
+	CodeActionsOnPut *[]protocol.CodeActionKind
 }
 
 // FilenameHandler contains a regular expression pattern that matches a filename
@@ -113,6 +268,33 @@ type FilenameHandler struct {
 
 	// ServerKey is the key in Config.File.Servers.
 	ServerKey string
+
+	// Overrides FormatOnPut of the server and the global one for files
+	// matched by Pattern, if set.
+	FormatOnPut *bool
+
+	// Overrides CodeActionsOnPut of the server and the global one for
+	// files matched by Pattern, if set.
+	CodeActionsOnPut *[]protocol.CodeActionKind
+}
+
+// Duration is a time.Duration that is written as a string
+// (e.g. "500ms" or "1m30s") in the configuration file.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
 }
 
 // Default returns the default Config.
@@ -124,22 +306,54 @@ func Default() *Config {
 	}
 	return &Config{
 		File: File{
-			ProxyNetwork:         "unix",
-			ProxyAddress:         filepath.Join(client.Namespace(), "acme-lsp.rpc"),
-			AcmeNetwork:          "unix",
-			AcmeAddress:          filepath.Join(client.Namespace(), "acme"),
-			WorkspaceDirectories: nil,
-			RootDirectory:        rootDir,
-			FormatOnPut:          true,
+			ProxyNetwork:              "unix",
+			ProxyAddress:              filepath.Join(client.Namespace(), "acme-lsp.rpc"),
+			AcmeNetwork:               "unix",
+			AcmeAddress:               filepath.Join(client.Namespace(), "acme"),
+			WorkspaceDirectories:      nil,
+			RootDirectory:             rootDir,
+			DiagnosticsUpdateInterval: Duration(time.Second),
+			MessageLevel:              "Warning",
+			MessageRequestTimeout:     Duration(5 * time.Minute),
+			FormatOnPut:               true,
 			CodeActionsOnPut: []protocol.CodeActionKind{
 				protocol.SourceOrganizeImports,
 			},
-			Servers:          nil,
-			FilenameHandlers: nil,
+			SkipFormatOnErrors: []string{"syntax"},
+			MaxFormatChange:    0.5,
+			Servers:            nil,
+			FilenameHandlers:   nil,
 		},
 	}
 }
 
+// OnPut returns whether a file handled by the server with the given key
+// is formatted when Put is executed, and the code actions run before
+// formatting it. They're given by the filename handler h, which is the
+// first handler of the server that matches the file (or nil if there is
+// none), the server and then the global configuration, whichever sets
+// them first.
+func (cfg *Config) OnPut(key string, h *FilenameHandler) (bool, []protocol.CodeActionKind) {
+	format, actions := &cfg.FormatOnPut, &cfg.CodeActionsOnPut
+	if cs, ok := cfg.Servers[key]; ok {
+		if cs.FormatOnPut != nil {
+			format = cs.FormatOnPut
+		}
+		if cs.CodeActionsOnPut != nil {
+			actions = cs.CodeActionsOnPut
+		}
+	}
+	if h != nil {
+		if h.FormatOnPut != nil {
+			format = h.FormatOnPut
+		}
+		if h.CodeActionsOnPut != nil {
+			actions = h.CodeActionsOnPut
+		}
+	}
+	return *format, *actions
+}
+
 func userConfigFilename() (string, error) {
 	dir, err := UserConfigDir()
 	if err != nil {
@@ -154,13 +368,11 @@ func userConfigFilename() (string, error) {
 // UserConfigDir/acme-lsp/config.toml if it exists. Otherwise, it'll
 // falling back to a default configuration.
 func Load() (*Config, error) {
-	filename := os.Getenv("ACME_LSP_CONFIG")
-	if filename == "" {
-		var err error
-		filename, err = userConfigFilename()
-		if err != nil {
-			return nil, err
-		}
+	filename, err := Filename()
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("ACME_LSP_CONFIG") == "" {
 		if _, err := os.Stat(filename); os.IsNotExist(err) {
 			return Default(), nil
 		}
@@ -186,6 +398,27 @@ func Load() (*Config, error) {
 	if cfg.File.RootDirectory == "" {
 		cfg.File.RootDirectory = def.File.RootDirectory
 	}
+	if cfg.File.DiagnosticsUpdateInterval <= 0 {
+		cfg.File.DiagnosticsUpdateInterval = def.File.DiagnosticsUpdateInterval
+	}
+	if cfg.File.MessageRequestTimeout <= 0 {
+		cfg.File.MessageRequestTimeout = def.File.MessageRequestTimeout
+	}
+	if cfg.File.MessageLevel == "" {
+		cfg.File.MessageLevel = def.File.MessageLevel
+	}
+	if cfg.File.SkipFormatOnErrors == nil {
+		cfg.File.SkipFormatOnErrors = def.File.SkipFormatOnErrors
+	}
+	if cfg.File.MaxFormatChange <= 0 {
+		cfg.File.MaxFormatChange = def.File.MaxFormatChange
+	}
+	if protocol.ParseMessageType(cfg.File.MessageLevel) == 0 {
+		return nil, cfg.problem(toml.Key{"MessageLevel"}, "invalid MessageLevel %q", cfg.File.MessageLevel)
+	}
+	if err := cfg.applyPresets(cfg.File.Presets); err != nil {
+		return nil, cfg.problem(toml.Key{"Presets"}, "%v", err)
+	}
 	cacheDir, err := os.UserCacheDir()
 	if err != nil {
 		return nil, err
@@ -197,9 +430,12 @@ func Load() (*Config, error) {
 	}
 	for key := range cfg.Servers {
 		if len(key) > 0 && key[0] == '_' {
-			return nil, fmt.Errorf("server key %q begins with underscore", key)
+			return nil, cfg.problem(toml.Key{"Servers", key}, "server key %q begins with underscore", key)
 		}
 		s := cfg.File.Servers[key]
+		if s.Shared && refersToRoot(append(append([]string{s.Dir}, s.Command...), s.Env...)...) {
+			return nil, cfg.problem(toml.Key{"Servers", key, "Shared"}, "server %q is shared, so its Command, Env and Dir can't refer to $ROOT", key)
+		}
 		if s.StderrFile != "" && !filepath.IsAbs(s.StderrFile) {
 			s.StderrFile = filepath.Join(cacheDir, s.StderrFile)
 		}
@@ -210,6 +446,57 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// problem returns a problem found in the configuration file by Load
+// as an error. The problem is located at key, if it's in the file.
+func (cfg *Config) problem(key toml.Key, format string, v ...interface{}) error {
+	p := &Problem{
+		Filename: cfg.filename,
+		Message:  fmt.Sprintf(format, v...),
+	}
+	if b, err := ioutil.ReadFile(cfg.filename); err == nil {
+		p.Line = findKeyPositions(b).key(key)
+	}
+	return p
+}
+
+// refersToRoot returns true if any of strs refers to $ROOT or ${ROOT}.
+func refersToRoot(strs ...string) bool {
+	found := false
+	for _, s := range strs {
+		os.Expand(s, func(v string) string {
+			found = found || v == "ROOT"
+			return ""
+		})
+	}
+	return found
+}
+
+// Filename returns the location of the configuration file loaded by
+// Load, which may not exist.
+func Filename() (string, error) {
+	if filename := os.Getenv("ACME_LSP_CONFIG"); filename != "" {
+		return filename, nil
+	}
+	return userConfigFilename()
+}
+
+// Reload loads Config from file system again, and parses the command
+// line flags previously given to ParseFlags, if any.
+func (cfg *Config) Reload() (*Config, error) {
+	c, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.parsed {
+		f := flag.NewFlagSet("reload", flag.ContinueOnError)
+		f.SetOutput(ioutil.Discard)
+		if err := c.ParseFlags(cfg.flags, f, cfg.arguments); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
 func load(filename string) (*Config, error) {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -237,6 +524,7 @@ func Write(w io.Writer, cfg *Config) error {
 func (cfg *Config) ParseFlags(flags Flags, f *flag.FlagSet, arguments []string) error {
 	var (
 		workspaces  string
+		presetNames string
 		userServers serverFlag
 		dialServers serverFlag
 	)
@@ -257,9 +545,12 @@ func (cfg *Config) ParseFlags(flags Flags, f *flag.FlagSet, arguments []string)
 	if flags&LangServerFlags != 0 {
 		f.BoolVar(&cfg.Verbose, "debug", cfg.Verbose, "turn on debugging prints (deprecated: use -v)")
 		f.StringVar(&cfg.RootDirectory, "rootdir", cfg.RootDirectory, "root directory used for LSP initialization")
+		f.BoolVar(&cfg.CheckConfig, "checkconfig", false, "check configuration files for problems and exit")
 		f.BoolVar(&cfg.HideDiagnostics, "hidediag", false, "hide diagnostics sent by LSP server")
 		f.BoolVar(&cfg.RPCTrace, "rpc.trace", false, "print the full rpc trace in lsp inspector format")
 		f.StringVar(&workspaces, "workspaces", "", "colon-separated list of initial workspace directories")
+		f.StringVar(&presetNames, "preset", "", fmt.Sprintf(`comma-separated list of predefined language server
+configurations to use. The available presets are: %v`, strings.Join(PresetNames(), ", ")))
 		f.Var(&userServers, "server", `map filename to language server command. The format is
 'handlers:cmd' where cmd is the LSP server command and handlers is
 a comma separated list of 'regexp[@lang]'. The regexp matches the
@@ -267,16 +558,28 @@ filename and lang is a language identifier. (e.g. '\.go$:gopls' or
 'go.mod$@go.mod,go.sum$@go.sum,\.go$@go:gopls')`)
 		f.Var(&dialServers, "dial", `map filename to language server address. The format is
 'handlers:host:port'. See -server flag for format of
-handlers. (e.g. '\.go$:localhost:4389')`)
+handlers. The address may be prefixed with 'unix:' to dial a unix
+domain socket or 'tcp:' (the default). (e.g. '\.go$:localhost:4389'
+or '\.go$:unix:/tmp/gopls.sock')`)
 	}
 	if err := f.Parse(arguments); err != nil {
 		return err
 	}
+	cfg.flags = flags
+	cfg.arguments = arguments
+	cfg.parsed = true
 
 	if flags&LangServerFlags != 0 {
 		if len(workspaces) > 0 {
 			cfg.WorkspaceDirectories = strings.Split(workspaces, ":")
 		}
+		if len(presetNames) > 0 {
+			names := strings.Split(presetNames, ",")
+			if err := cfg.applyPresets(names); err != nil {
+				return fmt.Errorf("invalid -preset flag: %v", err)
+			}
+			cfg.Presets = append(cfg.Presets, names...)
+		}
 		if cfg.Servers == nil {
 			cfg.Servers = make(map[string]*Server)
 		}
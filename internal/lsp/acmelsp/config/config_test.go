@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOnPut(t *testing.T) {
+	fixAll := []protocol.CodeActionKind{"source.fixAll"}
+	none := []protocol.CodeActionKind{}
+	cfg := Default()
+	cfg.Servers = map[string]*Server{
+		"gopls":   {Command: []string{"gopls"}},
+		"pyright": {Command: []string{"pyright-langserver"}, FormatOnPut: newBool(false)},
+		"clangd":  {Command: []string{"clangd"}, CodeActionsOnPut: &none},
+	}
+	cfg.FilenameHandlers = []FilenameHandler{
+		{Pattern: `_test\.go$`, ServerKey: "gopls", FormatOnPut: newBool(false)},
+		{Pattern: `\.go$`, ServerKey: "gopls", CodeActionsOnPut: &fixAll},
+		{Pattern: `\.py$`, ServerKey: "pyright"},
+		{Pattern: `\.c$`, ServerKey: "clangd"},
+	}
+	organize := cfg.CodeActionsOnPut
+
+	for _, tc := range []struct {
+		key     string
+		handler int // index of the matching handler, or -1 if none
+		format  bool
+		actions []protocol.CodeActionKind
+	}{
+		{"gopls", 1, true, fixAll},
+		{"gopls", 0, false, organize},
+		{"pyright", 2, false, organize},
+		{"clangd", 3, true, none},
+		{"gopls", -1, true, organize}, // no handler matches
+		{"nosuchserver", -1, true, organize},
+	} {
+		var h *FilenameHandler
+		if tc.handler >= 0 {
+			h = &cfg.FilenameHandlers[tc.handler]
+		}
+		format, actions := cfg.OnPut(tc.key, h)
+		if format != tc.format || !cmp.Equal(actions, tc.actions) {
+			t.Errorf("OnPut(%q, %v) = %v, %v; want %v, %v",
+				tc.key, h, format, actions, tc.format, tc.actions)
+		}
+	}
+}
+
+func TestLoadSharedRoot(t *testing.T) {
+	f, err := ioutil.TempFile("", "acme-lsp-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+[Servers.gopls]
+Command = ["gopls", "serve", "-listen=unix;$SOCKET"]
+Dir = "$ROOT"
+Shared = true
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("ACME_LSP_CONFIG", os.Getenv("ACME_LSP_CONFIG"))
+	os.Setenv("ACME_LSP_CONFIG", f.Name())
+
+	_, err = Load()
+	if err == nil {
+		t.Fatalf("loaded shared server that refers to $ROOT")
+	}
+	if p, ok := err.(*Problem); !ok || p.Filename != f.Name() || p.Line != 5 {
+		t.Errorf("Load error is %#v; want problem at %v:5", err, f.Name())
+	}
+}
+
+func TestLoadProblem(t *testing.T) {
+	f, err := ioutil.TempFile("", "acme-lsp-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`FormatOnPut = true
+MessageLevel = "Debug"
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("ACME_LSP_CONFIG", os.Getenv("ACME_LSP_CONFIG"))
+	os.Setenv("ACME_LSP_CONFIG", f.Name())
+
+	_, err = Load()
+	want := fmt.Sprintf(`%v:2: invalid MessageLevel "Debug"`, f.Name())
+	if _, ok := err.(*Problem); !ok || err.Error() != want {
+		t.Errorf("Load error is %v; want problem %v", err, want)
+	}
+}
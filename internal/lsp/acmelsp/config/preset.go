@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Preset is a predefined configuration of a commonly used LSP server.
+// Presets listed in File.Presets or given by the -preset flag are added
+// to Servers, keyed by the preset name, and to FilenameHandlers.
+//
+// A server in Servers with the same key as a preset overrides the
+// fields of the preset server that are set in it (e.g. only Options
+// or Command). The filename handlers of the preset are only added if
+// there are no filename handlers for the server key, and they're
+// checked after the filename handlers already in the configuration.
+type Preset struct {
+	// Server configuration.
+	Server Server
+
+	// Filename handlers of the server. ServerKey is ignored.
+	FilenameHandlers []FilenameHandler
+}
+
+var presets = map[string]*Preset{
+	"bash-language-server": {
+		Server: Server{Command: []string{"bash-language-server", "start"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.(sh|bash)$`, LanguageID: "shellscript"},
+		},
+	},
+	"clangd": {
+		Server: Server{Command: []string{"clangd"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.[ch]$`, LanguageID: "c"},
+			{Pattern: `\.(cc|cpp|cxx|hh|hpp|hxx)$`, LanguageID: "cpp"},
+		},
+	},
+	"gopls": {
+		Server: Server{Command: []string{"gopls", "serve"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `[/\\]go\.mod$`, LanguageID: "go.mod"},
+			{Pattern: `[/\\]go\.sum$`, LanguageID: "go.sum"},
+			{Pattern: `\.go$`, LanguageID: "go"},
+		},
+	},
+	"haskell-language-server": {
+		Server: Server{Command: []string{"haskell-language-server-wrapper", "--lsp"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.hs$`, LanguageID: "haskell"},
+		},
+	},
+	"lua-language-server": {
+		Server: Server{Command: []string{"lua-language-server"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.lua$`, LanguageID: "lua"},
+		},
+	},
+	"ocamllsp": {
+		Server: Server{Command: []string{"ocamllsp"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.mli?$`, LanguageID: "ocaml"},
+		},
+	},
+	"pyls": {
+		Server: Server{Command: []string{"pyls"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.py$`, LanguageID: "python"},
+		},
+	},
+	"pylsp": {
+		Server: Server{Command: []string{"pylsp"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.py$`, LanguageID: "python"},
+		},
+	},
+	"pyright": {
+		Server: Server{
+			Command:     []string{"pyright-langserver", "--stdio"},
+			FormatOnPut: newBool(false), // pyright doesn't format code
+		},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.py$`, LanguageID: "python"},
+		},
+	},
+	"rust-analyzer": {
+		Server: Server{Command: []string{"rust-analyzer"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.rs$`, LanguageID: "rust"},
+		},
+	},
+	"typescript-language-server": {
+		Server: Server{Command: []string{"typescript-language-server", "--stdio"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.[cm]?ts$`, LanguageID: "typescript"},
+			{Pattern: `\.tsx$`, LanguageID: "typescriptreact"},
+			{Pattern: `\.[cm]?js$`, LanguageID: "javascript"},
+			{Pattern: `\.jsx$`, LanguageID: "javascriptreact"},
+		},
+	},
+	"yaml-language-server": {
+		Server: Server{Command: []string{"yaml-language-server", "--stdio"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.ya?ml$`, LanguageID: "yaml"},
+		},
+	},
+	"zls": {
+		Server: Server{Command: []string{"zls"}},
+		FilenameHandlers: []FilenameHandler{
+			{Pattern: `\.zig$`, LanguageID: "zig"},
+		},
+	},
+}
+
+func newBool(b bool) *bool {
+	return &b
+}
+
+// PresetNames returns the sorted names of the available presets.
+func PresetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPresets adds the presets with the given names to cfg.
+func (cfg *Config) applyPresets(names []string) error {
+	for _, name := range names {
+		p, ok := presets[name]
+		if !ok {
+			return fmt.Errorf("unknown preset %q", name)
+		}
+		if cfg.Servers == nil {
+			cfg.Servers = make(map[string]*Server)
+		}
+		cfg.Servers[name] = p.server(cfg.Servers[name])
+
+		found := false
+		for _, h := range cfg.FilenameHandlers {
+			if h.ServerKey == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			for _, h := range p.FilenameHandlers {
+				h.ServerKey = name
+				cfg.FilenameHandlers = append(cfg.FilenameHandlers, h)
+			}
+		}
+	}
+	return nil
+}
+
+// server returns the preset server with the fields set in cs,
+// if it's not nil, overriding the preset.
+func (p *Preset) server(cs *Server) *Server {
+	s := p.Server
+	s.Command = append([]string(nil), s.Command...)
+	if cs == nil {
+		return &s
+	}
+	sv := reflect.ValueOf(&s).Elem()
+	cv := reflect.ValueOf(cs).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		f := cv.Field(i)
+		if !reflect.DeepEqual(f.Interface(), reflect.Zero(f.Type()).Interface()) {
+			sv.Field(i).Set(f)
+		}
+	}
+	return &s
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPresetPatterns(t *testing.T) {
+	for name, p := range presets {
+		if len(p.Server.Command) == 0 {
+			t.Errorf("preset %q has no command", name)
+		}
+		for _, h := range p.FilenameHandlers {
+			if _, err := regexp.Compile(h.Pattern); err != nil {
+				t.Errorf("preset %q: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestApplyPresets(t *testing.T) {
+	cfg := &Config{
+		File: File{
+			Presets: []string{"gopls", "clangd"},
+			Servers: map[string]*Server{
+				"gopls": {
+					Options: map[string]interface{}{"hoverKind": "FullDocumentation"},
+				},
+				"clangd": {
+					Command: []string{"clangd-12"},
+				},
+			},
+			FilenameHandlers: []FilenameHandler{
+				{Pattern: `\.c$`, LanguageID: "c", ServerKey: "clangd"},
+			},
+		},
+	}
+	if err := cfg.applyPresets(cfg.Presets); err != nil {
+		t.Fatalf("applyPresets failed: %v", err)
+	}
+	// Applying a preset again doesn't change anything.
+	f := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	if err := cfg.ParseFlags(LangServerFlags, f, []string{"-preset", "gopls"}); err != nil {
+		t.Fatalf("ParseFlags failed: %v", err)
+	}
+
+	wantServers := map[string]*Server{
+		"gopls": {
+			Command: []string{"gopls", "serve"},
+			Options: map[string]interface{}{"hoverKind": "FullDocumentation"},
+		},
+		"clangd": {
+			Command: []string{"clangd-12"},
+		},
+	}
+	if !cmp.Equal(cfg.Servers, wantServers) {
+		t.Errorf("servers are %v; want %v", cfg.Servers, wantServers)
+	}
+	wantHandlers := []FilenameHandler{
+		{Pattern: `\.c$`, LanguageID: "c", ServerKey: "clangd"},
+		{Pattern: `[/\\]go\.mod$`, LanguageID: "go.mod", ServerKey: "gopls"},
+		{Pattern: `[/\\]go\.sum$`, LanguageID: "go.sum", ServerKey: "gopls"},
+		{Pattern: `\.go$`, LanguageID: "go", ServerKey: "gopls"},
+	}
+	if !cmp.Equal(cfg.FilenameHandlers, wantHandlers) {
+		t.Errorf("filename handlers are %v; want %v", cfg.FilenameHandlers, wantHandlers)
+	}
+	if got := presets["gopls"].Server.Options; got != nil {
+		t.Errorf("preset modified: Options is %v", got)
+	}
+
+	if err := cfg.applyPresets([]string{"nosuchserver"}); err == nil {
+		t.Errorf("applyPresets succeeded for unknown preset")
+	}
+}
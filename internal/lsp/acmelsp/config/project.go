@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+)
+
+// ProjectFilename is the name of project configuration files.
+const ProjectFilename = ".acme-lsp.toml"
+
+// Project represents a project configuration file, which overrides the
+// configuration for files within the directory containing it. The
+// project configuration file of a file is found by walking up from
+// the directory containing the file. Project configuration files are
+// not merged with each other; only the closest one is used.
+//
+// The configuration is merged with Config as follows:
+//
+// FormatOnPut and CodeActionsOnPut replace the global ones in Config if
+// they're set. The ones set for a server or a filename handler still take
+// precedence over them.
+//
+// Options and Settings of a server in Servers replace the ones of the
+// server with the same key in Config, if they're set. Files within the
+// project are then handled by an instance of the server separate from
+// the one handling the files outside the project. Its root directory
+// and only workspace folder is the project directory, unless the server
+// uses RootMarkers.
+//
+// FilenameHandlers are checked before the ones in Config, so they take
+// precedence for files within the project. They may only refer to servers
+// in Config. A project can't define new servers, so that a project
+// configuration file (e.g. in a cloned repository) can't execute arbitrary
+// commands.
+type Project struct {
+	// Directory containing the project configuration file.
+	Dir string `toml:"-"`
+
+	// Overrides Config.FormatOnPut if set.
+	FormatOnPut *bool
+
+	// Overrides Config.CodeActionsOnPut if set.
+	CodeActionsOnPut *[]protocol.CodeActionKind
+
+	// Overrides for the servers in Config, keyed by the server key.
+	Servers map[string]*ProjectServer
+
+	// Filename handlers checked before Config.FilenameHandlers.
+	FilenameHandlers []FilenameHandler
+}
+
+// ProjectServer overrides the configuration of a server for a project.
+type ProjectServer struct {
+	// Replaces Server.Options if set.
+	Options interface{}
+
+	// Replaces Server.Settings if set.
+	Settings map[string]interface{}
+}
+
+// FindProject returns the project configuration of files within
+// directory dir, which is the closest project configuration file found
+// by walking up from dir. It returns nil if there is no such file.
+func FindProject(dir string) (*Project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		filename := filepath.Join(dir, ProjectFilename)
+		if _, err := os.Stat(filename); err == nil {
+			return loadProject(filename)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+func loadProject(filename string) (*Project, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var p Project
+	if err := toml.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	p.Dir = filepath.Dir(filename)
+	return &p, nil
+}
+
+// Apply returns a copy of cfg with the overrides in the project applied,
+// except the ones for servers. It's an error if the project refers to a
+// server not in cfg.
+func (p *Project) Apply(cfg *Config) (*Config, error) {
+	for key := range p.Servers {
+		if _, ok := cfg.Servers[key]; !ok {
+			return nil, fmt.Errorf("%v: server %q is not in the configuration", p.Dir, key)
+		}
+	}
+	for _, h := range p.FilenameHandlers {
+		if _, ok := cfg.Servers[h.ServerKey]; !ok {
+			return nil, fmt.Errorf("%v: server %q is not in the configuration", p.Dir, h.ServerKey)
+		}
+	}
+	c := *cfg
+	if p.FormatOnPut != nil {
+		c.FormatOnPut = *p.FormatOnPut
+	}
+	if p.CodeActionsOnPut != nil {
+		c.CodeActionsOnPut = *p.CodeActionsOnPut
+	}
+	c.FilenameHandlers = append(append([]FilenameHandler(nil), p.FilenameHandlers...), cfg.FilenameHandlers...)
+	return &c, nil
+}
+
+// Server returns the configuration of server cs with the given key
+// within the project, and whether it's different from cs.
+func (p *Project) Server(key string, cs *Server) (*Server, bool) {
+	ps, ok := p.Servers[key]
+	if !ok || (ps.Options == nil && ps.Settings == nil) {
+		return cs, false
+	}
+	s := *cs
+	if ps.Options != nil {
+		s.Options = ps.Options
+	}
+	if ps.Settings != nil {
+		s.Settings = ps.Settings
+	}
+	return &s, true
+}
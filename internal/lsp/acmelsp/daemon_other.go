@@ -0,0 +1,11 @@
+// +build plan9 windows
+
+package acmelsp
+
+import "os/exec"
+
+func detach(cmd *exec.Cmd) {}
+
+func isConnRefused(err error) bool {
+	return false
+}
@@ -0,0 +1,25 @@
+// +build !plan9,!windows
+
+package acmelsp
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach makes cmd run in a new session, so that it keeps running
+// after we exit and doesn't receive signals sent to our process group.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+func isConnRefused(err error) bool {
+	if err, ok := err.(*net.OpError); ok {
+		if err, ok := err.Err.(*os.SyscallError); ok {
+			return err.Err == syscall.ECONNREFUSED
+		}
+	}
+	return false
+}
@@ -2,11 +2,14 @@ package acmelsp
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fhs/acme-lsp/internal/acmeutil"
 	"github.com/fhs/acme-lsp/internal/lsp"
+	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
 	"github.com/fhs/acme-lsp/internal/lsp/protocol"
 )
 
@@ -16,8 +19,15 @@ import (
 type diagWin struct {
 	name string // window name
 	*acmeutil.Win
-	paramsChan chan *protocol.PublishDiagnosticsParams
-	updateChan chan struct{}
+	updateChan  chan struct{} // user requested update
+	pendingChan chan struct{} // pending has new entries
+
+	// Diagnostics that haven't been processed yet. Only the latest
+	// diagnostics for each document is kept, and dropped documents
+	// are processed before new diagnostics.
+	pending        map[protocol.DocumentURI][]protocol.Diagnostic
+	pendingDropped []protocol.DocumentURI
+	pendingMu      sync.Mutex
 
 	dead bool // window has been closed
 	mu   sync.Mutex
@@ -25,10 +35,11 @@ type diagWin struct {
 
 func newDiagWin(name string) *diagWin {
 	return &diagWin{
-		name:       name,
-		updateChan: make(chan struct{}),
-		paramsChan: make(chan *protocol.PublishDiagnosticsParams, 100),
-		dead:       true,
+		name:        name,
+		updateChan:  make(chan struct{}),
+		pendingChan: make(chan struct{}, 1),
+		pending:     make(map[protocol.DocumentURI][]protocol.Diagnostic),
+		dead:        true,
 	}
 }
 
@@ -99,18 +110,209 @@ func (dw *diagWin) update(diags map[protocol.DocumentURI][]protocol.Diagnostic)
 	return dw.Ctl("clean")
 }
 
+// WriteDiagnostics implements DiagnosticsWriter.
+// It never blocks, so that the LSP connection isn't stalled by a burst
+// of diagnostics. Diagnostics for a document replace earlier diagnostics
+// for the same document that haven't been processed yet.
 func (dw *diagWin) WriteDiagnostics(params *protocol.PublishDiagnosticsParams) {
-	dw.paramsChan <- params
+	dw.pendingMu.Lock()
+	dw.pending[params.URI] = params.Diagnostics
+	dw.pendingMu.Unlock()
+	dw.notifyPending()
+}
+
+// DropDiagnostics implements DiagnosticsWriter.
+func (dw *diagWin) DropDiagnostics(uri protocol.DocumentURI) {
+	dw.pendingMu.Lock()
+	for u := range dw.pending {
+		if uriWithin(u, uri) {
+			delete(dw.pending, u)
+		}
+	}
+	dw.pendingDropped = append(dw.pendingDropped, uri)
+	dw.pendingMu.Unlock()
+	dw.notifyPending()
+}
+
+func (dw *diagWin) notifyPending() {
+	select {
+	case dw.pendingChan <- struct{}{}:
+	default: // already notified
+	}
+}
+
+// applyPending applies pending changes to diags and
+// returns true if diags has been modified.
+func (dw *diagWin) applyPending(diags map[protocol.DocumentURI][]protocol.Diagnostic) bool {
+	dw.pendingMu.Lock()
+	pending, dropped := dw.pending, dw.pendingDropped
+	dw.pending = make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	dw.pendingDropped = nil
+	dw.pendingMu.Unlock()
+
+	changed := false
+	for _, uri := range dropped {
+		for u := range diags {
+			if uriWithin(u, uri) {
+				delete(diags, u)
+				changed = true
+			}
+		}
+	}
+	for uri, d := range pending {
+		if len(diags[uri]) == 0 && len(d) == 0 {
+			continue
+		}
+		if len(d) == 0 {
+			delete(diags, uri)
+		} else {
+			diags[uri] = d
+		}
+		changed = true
+	}
+	return changed
+}
+
+// uriWithin returns true if uri is the same as dir or is contained within it.
+func uriWithin(uri, dir protocol.DocumentURI) bool {
+	return uri == dir || strings.HasPrefix(uri, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// diagMerger merges diagnostics for the same document sent by more than
+// one server and writes them to an underlying DiagnosticsWriter.
+type diagMerger struct {
+	w      DiagnosticsWriter
+	diags  map[protocol.DocumentURI]map[string][]protocol.Diagnostic // keyed by URI and source
+	closed map[protocol.DocumentURI]bool                             // documents whose diagnostics are ignored
+	mu     sync.Mutex
+}
+
+func newDiagMerger(w DiagnosticsWriter) *diagMerger {
+	return &diagMerger{
+		w:      w,
+		diags:  make(map[protocol.DocumentURI]map[string][]protocol.Diagnostic),
+		closed: make(map[protocol.DocumentURI]bool),
+	}
+}
+
+// closeDocument drops diagnostics for the document uri, which was closed,
+// and ignores the diagnostics published for it afterwards (e.g. by a
+// server that hadn't processed didClose yet) until it's opened again.
+func (m *diagMerger) closeDocument(uri protocol.DocumentURI) {
+	m.mu.Lock()
+	m.closed[uri] = true
+	m.mu.Unlock()
+
+	m.DropDiagnostics(uri)
+}
+
+// openDocument stops ignoring diagnostics for the document uri.
+func (m *diagMerger) openDocument(uri protocol.DocumentURI) {
+	m.mu.Lock()
+	delete(m.closed, uri)
+	m.mu.Unlock()
 }
 
-func NewDiagnosticsWriter() DiagnosticsWriter {
+// writer returns a DiagnosticsWriter for diagnostics sent by source.
+func (m *diagMerger) writer(source string) DiagnosticsWriter {
+	return &sourceDiagWriter{m: m, source: source}
+}
+
+// DropDiagnostics implements DiagnosticsWriter.
+// It drops diagnostics from all sources.
+func (m *diagMerger) DropDiagnostics(uri protocol.DocumentURI) {
+	m.mu.Lock()
+	for u := range m.diags {
+		if uriWithin(u, uri) {
+			delete(m.diags, u)
+		}
+	}
+	m.mu.Unlock()
+
+	m.w.DropDiagnostics(uri)
+}
+
+// WriteDiagnostics implements DiagnosticsWriter.
+// It writes diagnostics from an unknown source, replacing diagnostics
+// from all sources.
+func (m *diagMerger) WriteDiagnostics(params *protocol.PublishDiagnosticsParams) {
+	m.mu.Lock()
+	closed := m.closed[params.URI]
+	delete(m.diags, params.URI)
+	m.mu.Unlock()
+
+	if closed {
+		return
+	}
+	m.w.WriteDiagnostics(params)
+}
+
+func (m *diagMerger) write(source string, params *protocol.PublishDiagnosticsParams) {
+	m.mu.Lock()
+	if m.closed[params.URI] {
+		m.mu.Unlock()
+		return
+	}
+	bySource := m.diags[params.URI]
+	if bySource == nil {
+		bySource = make(map[string][]protocol.Diagnostic)
+		m.diags[params.URI] = bySource
+	}
+	if len(params.Diagnostics) == 0 {
+		delete(bySource, source)
+	} else {
+		bySource[source] = params.Diagnostics
+	}
+	var sources []string
+	for src := range bySource {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+	var diags []protocol.Diagnostic
+	for _, src := range sources {
+		diags = append(diags, bySource[src]...)
+	}
+	if len(bySource) == 0 {
+		delete(m.diags, params.URI)
+	}
+	m.mu.Unlock()
+
+	m.w.WriteDiagnostics(&protocol.PublishDiagnosticsParams{
+		URI:         params.URI,
+		Version:     params.Version,
+		Diagnostics: diags,
+	})
+}
+
+// sourceDiagWriter implements DiagnosticsWriter for one source of diagnostics.
+type sourceDiagWriter struct {
+	m      *diagMerger
+	source string
+}
+
+func (w *sourceDiagWriter) WriteDiagnostics(params *protocol.PublishDiagnosticsParams) {
+	w.m.write(w.source, params)
+}
+
+func (w *sourceDiagWriter) DropDiagnostics(uri protocol.DocumentURI) {
+	w.m.DropDiagnostics(uri)
+}
+
+// NewDiagnosticsWriter returns a DiagnosticsWriter that writes diagnostics to
+// the /LSP/Diagnostics acme window, at most once every cfg.DiagnosticsUpdateInterval.
+func NewDiagnosticsWriter(cfg *config.Config) DiagnosticsWriter {
 	dw := newDiagWin("/LSP/Diagnostics")
 
+	interval := time.Duration(cfg.DiagnosticsUpdateInterval)
+	if interval <= 0 {
+		interval = time.Second
+	}
+
 	// Collect stream of diagnostics updates and write them all
 	// after certain interval if they need to be updated.
 	go func() {
 		diags := make(map[protocol.DocumentURI][]protocol.Diagnostic)
-		ticker := time.NewTicker(time.Second)
+		ticker := time.NewTicker(interval)
 		needsUpdate := false
 		for {
 			select {
@@ -121,15 +323,14 @@ func NewDiagnosticsWriter() DiagnosticsWriter {
 				}
 
 			case <-dw.updateChan: // user request
+				dw.applyPending(diags)
 				dw.update(diags)
 				needsUpdate = false
 
-			case params := <-dw.paramsChan:
-				if len(diags[params.URI]) == 0 && len(params.Diagnostics) == 0 {
-					continue
+			case <-dw.pendingChan:
+				if dw.applyPending(diags) {
+					needsUpdate = true
 				}
-				diags[params.URI] = params.Diagnostics
-				needsUpdate = true
 			}
 		}
 	}()
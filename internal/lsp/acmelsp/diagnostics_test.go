@@ -0,0 +1,115 @@
+package acmelsp
+
+import (
+	"testing"
+
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiagWinApplyPending(t *testing.T) {
+	diag := func(msg string) []protocol.Diagnostic {
+		return []protocol.Diagnostic{{Message: msg}}
+	}
+	dw := newDiagWin("/LSP/Diagnostics")
+	diags := map[protocol.DocumentURI][]protocol.Diagnostic{
+		"file:///mod1/a.go":  diag("a"),
+		"file:///mod1/b.go":  diag("b"),
+		"file:///mod10/c.go": diag("c"),
+	}
+
+	dw.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///mod2/d.go", Diagnostics: diag("d1")})
+	dw.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///mod2/d.go", Diagnostics: diag("d2")})
+	dw.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///mod1/a.go", Diagnostics: diag("stale")})
+	dw.DropDiagnostics("file:///mod1")
+	dw.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///mod1/b.go", Diagnostics: diag("b2")})
+
+	if !dw.applyPending(diags) {
+		t.Errorf("applyPending returned false; want true")
+	}
+	want := map[protocol.DocumentURI][]protocol.Diagnostic{
+		"file:///mod1/b.go":  diag("b2"),
+		"file:///mod10/c.go": diag("c"),
+		"file:///mod2/d.go":  diag("d2"),
+	}
+	if !cmp.Equal(diags, want) {
+		t.Errorf("diagnostics are %v; want %v", diags, want)
+	}
+	if dw.applyPending(diags) {
+		t.Errorf("applyPending returned true with nothing pending")
+	}
+
+	dw.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///mod3/e.go"})
+	if dw.applyPending(diags) {
+		t.Errorf("applyPending returned true for empty diagnostics of unknown file")
+	}
+}
+
+func TestDiagMerger(t *testing.T) {
+	diag := func(msg string) []protocol.Diagnostic {
+		return []protocol.Diagnostic{{Message: msg}}
+	}
+	dw := newDiagWin("/LSP/Diagnostics")
+	m := newDiagMerger(dw)
+	gopls := m.writer("gopls")
+	lint := m.writer("lint")
+
+	gopls.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///a.go", Diagnostics: diag("compile")})
+	lint.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///a.go", Diagnostics: diag("lint")})
+	lint.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///b.go", Diagnostics: diag("lint b")})
+	gopls.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///b.go"})
+
+	diags := make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	dw.applyPending(diags)
+	want := map[protocol.DocumentURI][]protocol.Diagnostic{
+		"file:///a.go": append(diag("compile"), diag("lint")...),
+		"file:///b.go": diag("lint b"),
+	}
+	if !cmp.Equal(diags, want) {
+		t.Errorf("diagnostics are %v; want %v", diags, want)
+	}
+
+	gopls.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///a.go"})
+	m.DropDiagnostics("file:///b.go")
+	lint.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///b.go", Diagnostics: diag("lint b2")})
+	dw.applyPending(diags)
+	want = map[protocol.DocumentURI][]protocol.Diagnostic{
+		"file:///a.go": diag("lint"),
+		"file:///b.go": diag("lint b2"),
+	}
+	if !cmp.Equal(diags, want) {
+		t.Errorf("diagnostics are %v; want %v", diags, want)
+	}
+}
+
+func TestDiagMergerClosedDocument(t *testing.T) {
+	diag := func(msg string) []protocol.Diagnostic {
+		return []protocol.Diagnostic{{Message: msg}}
+	}
+	dw := newDiagWin("/LSP/Diagnostics")
+	m := newDiagMerger(dw)
+	gopls := m.writer("gopls")
+
+	gopls.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///a.go", Diagnostics: diag("compile")})
+	gopls.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///b.go", Diagnostics: diag("compile b")})
+	m.closeDocument("file:///a.go")
+	// Published by the server before it processed didClose.
+	gopls.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///a.go", Diagnostics: diag("stale")})
+
+	diags := make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	dw.applyPending(diags)
+	want := map[protocol.DocumentURI][]protocol.Diagnostic{
+		"file:///b.go": diag("compile b"),
+	}
+	if !cmp.Equal(diags, want) {
+		t.Errorf("diagnostics after closing a.go are %v; want %v", diags, want)
+	}
+
+	m.openDocument("file:///a.go")
+	gopls.WriteDiagnostics(&protocol.PublishDiagnosticsParams{URI: "file:///a.go", Diagnostics: diag("compile again")})
+	dw.applyPending(diags)
+	want["file:///a.go"] = diag("compile again")
+	if !cmp.Equal(diags, want) {
+		t.Errorf("diagnostics after reopening a.go are %v; want %v", diags, want)
+	}
+}
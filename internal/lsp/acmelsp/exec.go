@@ -2,6 +2,7 @@ package acmelsp
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"log"
@@ -9,19 +10,43 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fhs/acme-lsp/internal/lsp"
 	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
 	"github.com/fhs/acme-lsp/internal/lsp/protocol"
 	"github.com/fhs/acme-lsp/internal/lsp/proxy"
+	"github.com/fhs/acme-lsp/internal/lsp/text"
 )
 
+// shutdownTimeout is how long we wait for a server to respond to the
+// shutdown request.
+const shutdownTimeout = 5 * time.Second
+
+// exitTimeout is how long we wait for a server process to exit after
+// the exit notification, before killing it.
+const exitTimeout = 2 * time.Second
+
 type Server struct {
 	conn   net.Conn
 	Client *Client
+
+	root     string      // project root, or empty if RootMarkers isn't used
+	lastUsed time.Time   // last time the server was used
+	idle     *time.Timer // fires when the server may have been idle for IdleTimeout
+
+	proc     *serverProcess // running process, or nil if the server was dialed
+	shared   bool           // connected to a daemon shared with other acme-lsp instances
+	start    time.Time      // when the server was last (re)started
+	restarts int            // number of times the server was restarted
+	stopped  bool           // server was stopped, so it shouldn't be restarted
+	gaveUp   func()         // called, if not nil, when restarting the server is given up
+	mu       sync.Mutex
 }
 
 func (s *Server) Close() {
@@ -30,133 +55,745 @@ func (s *Server) Close() {
 	}
 }
 
-func execServer(cs *config.Server, cfg *ClientConfig) (*Server, error) {
-	args := cs.Command
+// shutdown sends the shutdown request and exit notification to the
+// server, making sure it isn't restarted. The server process is killed
+// if it doesn't exit within exitTimeout. A shared daemon is only
+// disconnected from, since it's still used by other acme-lsp instances.
+func (s *Server) shutdown(ctx context.Context) {
+	s.mu.Lock()
+	s.stopped = true
+	proc, conn := s.proc, s.conn
+	s.mu.Unlock()
 
-	stderr := os.Stderr
-	if cs.StderrFile != "" {
-		f, err := os.Create(cs.StderrFile)
-		if err != nil {
-			return nil, fmt.Errorf("could not create server StderrFile: %v", err)
+	if s.shared {
+		conn.Close()
+		return
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	err := s.Client.Shutdown(sctx)
+	cancel()
+	if err == nil {
+		err = s.Client.Exit(ctx)
+	}
+	if err != nil && Verbose {
+		log.Printf("shutdown of language server %v failed: %v", s.Client.serverName(), err)
+	}
+	conn.Close()
+
+	if proc == nil {
+		return
+	}
+	t := time.NewTimer(exitTimeout)
+	defer t.Stop()
+	select {
+	case <-proc.exited:
+	case <-t.C:
+		log.Printf("language server %v did not exit; killing it", s.Client.serverName())
+		proc.cmd.Process.Kill()
+	}
+}
+
+// Defaults for the restart policy of config.Server.
+const (
+	defaultMaxRestarts     = 5
+	defaultRestartBackoff  = time.Second
+	defaultCrashLoopWindow = time.Minute
+	maxRestartBackoff      = time.Minute
+)
+
+// restartPolicy decides when a server that exited is restarted.
+type restartPolicy struct {
+	maxRestarts int           // maximum number of restarts within window
+	backoff     time.Duration // delay before first restart within window
+	window      time.Duration // crash-loop detection window
+	exits       []time.Time   // times the server exited within window
+}
+
+func newRestartPolicy(cs *config.Server) *restartPolicy {
+	p := &restartPolicy{
+		maxRestarts: cs.MaxRestarts,
+		backoff:     time.Duration(cs.RestartBackoff),
+		window:      time.Duration(cs.CrashLoopWindow),
+	}
+	if p.maxRestarts == 0 {
+		p.maxRestarts = defaultMaxRestarts
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultRestartBackoff
+	}
+	if p.window <= 0 {
+		p.window = defaultCrashLoopWindow
+	}
+	return p
+}
+
+// next records that the server exited at time now, and returns how long
+// to wait before restarting it. The wait is doubled for each exit within
+// the crash-loop window. It returns false if the server has exited too
+// many times within the window and shouldn't be restarted.
+func (p *restartPolicy) next(now time.Time) (time.Duration, bool) {
+	i := 0
+	for i < len(p.exits) && now.Sub(p.exits[i]) >= p.window {
+		i++
+	}
+	p.exits = append(p.exits[i:], now)
+
+	n := len(p.exits)
+	if n > p.maxRestarts {
+		return 0, false
+	}
+	d := p.backoff
+	for i := 1; i < n && d < maxRestartBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRestartBackoff {
+		d = maxRestartBackoff
+	}
+	return d, true
+}
+
+// serverProcess is a LSP server process started by acme-lsp.
+type serverProcess struct {
+	cmd    *exec.Cmd
+	exited chan struct{} // closed when cmd exits
+	err    error         // error returned by cmd.Wait; set before exited is closed
+}
+
+// startProcess starts cmd. The connection conn is closed when cmd exits.
+func startProcess(cmd *exec.Cmd, conn net.Conn) (*serverProcess, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to execute language server: %v", err)
+	}
+	proc := &serverProcess{
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.exited)
+		conn.Close() // TODO(fhs): cancel using context?
+	}()
+	return proc, nil
+}
+
+// connector establishes connections to a LSP server.
+type connector interface {
+	// connect returns a new connection to the server. If a server
+	// process that exits when the connection is closed was started
+	// to serve the connection, it's also returned.
+	connect() (net.Conn, *serverProcess, error)
+}
+
+// execConnector executes the server command and speaks LSP on its
+// stdin/stdout.
+type execConnector struct {
+	args   []string
+	env    []string
+	dir    string
+	stderr io.Writer // can be nil
+}
+
+func (ec *execConnector) connect() (net.Conn, *serverProcess, error) {
+	p0, p1 := net.Pipe()
+	// TODO(fhs): use CommandContext?
+	cmd := exec.Command(ec.args[0], ec.args[1:]...)
+	cmd.Env = ec.env
+	cmd.Dir = ec.dir
+	cmd.Stdin = p0
+	cmd.Stdout = p0
+	if ec.stderr != nil {
+		cmd.Stderr = ec.stderr
+	}
+	proc, err := startProcess(cmd, p1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p1, proc, nil
+}
+
+// dialConnector dials the server at a network address.
+type dialConnector struct {
+	network, address string
+}
+
+func (dc *dialConnector) connect() (net.Conn, *serverProcess, error) {
+	conn, err := net.Dial(dc.network, dc.address)
+	return conn, nil, err
+}
+
+// sharedDaemonTimeout is how long we wait for a newly started shared
+// daemon to start listening.
+const sharedDaemonTimeout = 10 * time.Second
+
+// sharedConnector connects to a daemon listening on a unix domain
+// socket, which is shared among acme-lsp instances. It starts the
+// daemon if it's not running.
+type sharedConnector struct {
+	socket string
+	execConnector
+}
+
+func (sc *sharedConnector) connect() (net.Conn, *serverProcess, error) {
+	conn, err := net.Dial("unix", sc.socket)
+	if err == nil {
+		return conn, nil, nil
+	}
+
+	if isConnRefused(err) {
+		// Remove stale socket left behind by a daemon that died.
+		os.Remove(sc.socket)
+	}
+
+	cmd := exec.Command(sc.args[0], sc.args[1:]...)
+	cmd.Env = sc.env
+	cmd.Dir = sc.dir
+	if sc.stderr != nil {
+		cmd.Stderr = sc.stderr
+	}
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to execute shared language server: %v", err)
+	}
+	go cmd.Wait() // outlives us if we exit first
+
+	deadline := time.Now().Add(sharedDaemonTimeout)
+	for {
+		time.Sleep(100 * time.Millisecond)
+		conn, err = net.Dial("unix", sc.socket)
+		if err == nil {
+			return conn, nil, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, fmt.Errorf("shared language server not listening on %v: %v", sc.socket, err)
 		}
-		stderr = f
 	}
+}
 
-	startCommand := func() (*exec.Cmd, net.Conn, error) {
-		p0, p1 := net.Pipe()
-		// TODO(fhs): use CommandContext?
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdin = p0
-		cmd.Stdout = p0
-		if Verbose || cs.StderrFile != "" {
-			cmd.Stderr = stderr
+// sharedSocket returns the path of the unix domain socket the shared
+// daemon for the server command args, with additional environment
+// variables env and working directory dir, listens on.
+func sharedSocket(args, env []string, dir string) string {
+	h := sha256.New()
+	for _, s := range [][]string{args, env, {dir}} {
+		for _, a := range s {
+			fmt.Fprintf(h, "%q ", a)
 		}
-		if err := cmd.Start(); err != nil {
-			return nil, nil, fmt.Errorf("failed to execute language server: %v", err)
+		fmt.Fprintf(h, "\n")
+	}
+	name := fmt.Sprintf("acme-lsp-%v-%x.sock", os.Getuid(), h.Sum(nil)[:8])
+	return filepath.Join(os.TempDir(), name)
+}
+
+// execServer executes the server command cs.Command and connects to it.
+// If cs.Shared is set, the command is instead executed as a daemon
+// shared by all acme-lsp instances, if it isn't running already.
+// The server is restarted according to its restart policy if it exits,
+// in which case restarted is called, if not nil.
+func execServer(cs *config.Server, cfg *ClientConfig, restarted func()) (*Server, error) {
+	root, err := filepath.Abs(cfg.RootDirectory)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{"ROOT": root}
+	var socket string
+	if cs.Shared {
+		// The socket depends on the command with $SOCKET unexpanded,
+		// so that the command can refer to it.
+		vars["SOCKET"] = "$SOCKET"
+		socket = sharedSocket(expandCommand(cs, vars))
+		vars["SOCKET"] = socket
+	}
+	var ec execConnector
+	ec.args, ec.env, ec.dir = expandCommand(cs, vars)
+	if len(ec.env) > 0 {
+		ec.env = append(os.Environ(), ec.env...)
+	}
+	if cfg.Stderr != nil {
+		ec.stderr = cfg.Stderr
+	} else if Verbose && !cs.Shared {
+		ec.stderr = os.Stderr
+	}
+
+	if cs.Shared {
+		return connectServer(cs, cfg, &sharedConnector{socket: socket, execConnector: ec}, restarted)
+	}
+	return connectServer(cs, cfg, &ec, restarted)
+}
+
+// expandCommand returns the command, additional environment variables
+// and working directory of cs, with variables expanded using expandVars.
+func expandCommand(cs *config.Server, vars map[string]string) (args, env []string, dir string) {
+	for _, a := range cs.Command {
+		args = append(args, expandVars(a, vars))
+	}
+	for _, e := range cs.Env {
+		env = append(env, expandVars(e, vars))
+	}
+	return args, env, expandVars(cs.Dir, vars)
+}
+
+// expandVars replaces $VAR or ${VAR} in s with vars[VAR] if it's
+// present, or else the value of the environment variable VAR.
+func expandVars(s string, vars map[string]string) string {
+	return os.Expand(s, func(v string) string {
+		if val, ok := vars[v]; ok {
+			return val
+		}
+		return os.Getenv(v)
+	})
+}
+
+// dialAddress returns the network and address of a LSP server address,
+// which is either "unix:path", "tcp:host:port", or "host:port".
+func dialAddress(addr string) (network, address string) {
+	for _, network := range []string{"unix", "tcp"} {
+		if strings.HasPrefix(addr, network+":") {
+			return network, addr[len(network)+1:]
 		}
-		return cmd, p1, nil
 	}
-	cmd, p1, err := startCommand()
+	return "tcp", addr
+}
+
+// dialServer connects to the server at cs.Address. If the connection
+// terminates, it's reconnected according to the server's restart policy,
+// in which case restarted is called, if not nil.
+func dialServer(cs *config.Server, cfg *ClientConfig, restarted func()) (*Server, error) {
+	network, address := dialAddress(cs.Address)
+	return connectServer(cs, cfg, &dialConnector{network: network, address: address}, restarted)
+}
+
+// connectServer connects to the server using ctr and initializes it.
+// When the server terminates, either because its process exited or the
+// connection was lost, a new connection is established according to the
+// server's restart policy, in which case the server is reinitialized and
+// restarted is called, if not nil.
+func connectServer(cs *config.Server, cfg *ClientConfig, ctr connector, restarted func()) (*Server, error) {
+	conn, proc, err := ctr.connect()
 	if err != nil {
 		return nil, err
 	}
+	c, err := NewClient(conn, cfg)
+	if err != nil {
+		conn.Close()
+		if proc != nil {
+			proc.cmd.Process.Kill()
+		}
+		return nil, fmt.Errorf("failed to connect to language server %v: %v", serverDesc(cs), err)
+	}
+	_, shared := ctr.(*sharedConnector)
 	srv := &Server{
-		conn: p1,
+		conn:   conn,
+		Client: c,
+		proc:   proc,
+		shared: shared,
+		start:  time.Now(),
 	}
 
-	// Restart server if it dies.
+	// Restart or reconnect to server if it terminates.
 	go func() {
+		policy := newRestartPolicy(cs)
 		for {
-			err := cmd.Wait()
-			log.Printf("language server %v exited: %v; restarting...", args[0], err)
+			var why, verb string
+			<-c.disconnected()
+			if proc != nil {
+				<-proc.exited
+				why, verb = fmt.Sprintf("exited: %v", proc.err), "restart"
+			} else {
+				why, verb = "disconnected", "reconnect"
+			}
 
-			// TODO(fhs): cancel using context?
-			srv.conn.Close()
+			for {
+				srv.mu.Lock()
+				stopped := srv.stopped
+				srv.mu.Unlock()
+				if stopped {
+					return
+				}
+				delay, ok := policy.next(time.Now())
+				if !ok {
+					c.report(protocol.Error, "terminated %v times within %v; not %ving until it's needed again (or use \"L servers restart %v\")",
+						len(policy.exits), policy.window, verb, cfg.ServerKey)
+					srv.mu.Lock()
+					srv.stopped = true
+					gaveUp := srv.gaveUp
+					srv.mu.Unlock()
+					if gaveUp != nil {
+						gaveUp()
+					}
+					return
+				}
+				c.report(protocol.Warning, "%v; %ving in %v", why, verb, delay)
+				time.Sleep(delay)
 
-			cmd, p1, err = startCommand()
-			if err != nil {
-				log.Printf("%v", err)
-				return
-			}
-			srv.conn = p1
+				srv.mu.Lock()
+				if srv.stopped {
+					srv.mu.Unlock()
+					return
+				}
+				conn, proc, err = ctr.connect()
+				if err != nil {
+					srv.mu.Unlock()
+					why = err.Error()
+					continue
+				}
+				srv.conn = conn
+				srv.proc = proc
+				srv.start = time.Now()
+				srv.restarts++
+				srv.mu.Unlock()
 
-			go func() {
 				// Reinitialize existing client instead of creating a new one
 				// because it's still being used.
-				if err := srv.Client.init(p1, cfg); err != nil {
-					log.Printf("initialize after server restart failed: %v", err)
-					cmd.Process.Kill()
-					srv.conn.Close()
+				if err := c.init(conn, cfg); err != nil {
+					conn.Close()
+					if proc != nil {
+						proc.cmd.Process.Kill()
+						<-proc.exited
+					}
+					why = fmt.Sprintf("initialize after %v failed: %v", verb, err)
+					continue
 				}
-			}()
+				break
+			}
+			c.report(protocol.Info, "%ved", verb)
+			if restarted != nil {
+				go restarted()
+			}
 		}
 	}()
-
-	srv.Client, err = NewClient(p1, cfg)
-	if err != nil {
-		cmd.Process.Kill()
-		return nil, fmt.Errorf("failed to connect to language server %q: %v", args, err)
-	}
 	return srv, nil
 }
 
-func dialServer(cs *config.Server, cfg *ClientConfig) (*Server, error) {
-	conn, err := net.Dial("tcp", cs.Address)
-	if err != nil {
-		return nil, err
-	}
-	c, err := NewClient(conn, cfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to language server at %v: %v", cs.Address, err)
+// serverDesc describes the server cs in error messages.
+func serverDesc(cs *config.Server) string {
+	if len(cs.Command) > 0 {
+		return fmt.Sprintf("%q", cs.Command)
 	}
-	return &Server{
-		conn:   conn,
-		Client: c,
-	}, nil
+	return fmt.Sprintf("at %v", cs.Address)
 }
 
-// ServerInfo holds information about a LSP server and optionally a connection to it.
+// ServerInfo holds information about a LSP server and optionally connections to it.
 type ServerInfo struct {
 	*config.Server
 	*config.FilenameHandler
 
-	Re     *regexp.Regexp // filename regular expression
-	Logger *log.Logger    // Logger for config.Server.LogFile
-	srv    *Server        // running server instance
+	Re     *regexp.Regexp     // filename regular expression
+	Logger *log.Logger        // Logger for config.Server.LogFile
+	srvs   map[string]*Server // running server instances keyed by project root
+	stderr *os.File           // config.Server.StderrFile opened for writing, or nil
+
+	// Server instances being started, keyed by project root.
+	starting map[string]*serverStart
+
+	// Project roots of the server instances removed from srvs because
+	// they kept terminating or were stopped, whose files must be
+	// reopened when the server is started again.
+	gaveUp map[string]bool
+
+	// Directory of the project configuration file that configured the
+	// server, if any. It's the root of the server's instance.
+	project string
+
+	// Current Settings and ScopeSettings of Server, which may have
+	// been changed by reloading the configuration.
+	settings      map[string]interface{}
+	scopeSettings map[string]map[string]interface{}
+
+	mu sync.Mutex // guards srvs, starting, gaveUp, their lastUsed and idle fields, and settings
+
+	// Project roots found by root, keyed by directory.
+	roots  map[string]string
+	rootMu sync.Mutex
+}
+
+// root returns the project root directory of filename, which is the
+// closest ancestor directory containing one of RootMarkers. If RootMarkers
+// is empty or no project root is found, it returns the directory of the
+// project configuration file that configured the server, which is empty
+// if the server is configured by the user configuration. The roots are
+// cached until the configuration is reloaded.
+func (info *ServerInfo) root(filename string) string {
+	if len(info.RootMarkers) == 0 {
+		return info.project
+	}
+	dir := filepath.Dir(filename)
+	info.rootMu.Lock()
+	root, ok := info.roots[dir]
+	info.rootMu.Unlock()
+	if ok {
+		return root
+	}
+
+	root = info.findRoot(dir)
+	info.rootMu.Lock()
+	if info.roots == nil {
+		info.roots = make(map[string]string)
+	}
+	info.roots[dir] = root
+	info.rootMu.Unlock()
+	return root
+}
+
+// clearRoots forgets the project roots found by root.
+func (info *ServerInfo) clearRoots() {
+	info.rootMu.Lock()
+	info.roots = nil
+	info.rootMu.Unlock()
+}
+
+// findRoot returns the closest ancestor directory of dir, including dir,
+// containing one of RootMarkers, or info.project if there is none.
+func (info *ServerInfo) findRoot(dir string) string {
+	for {
+		for _, m := range info.RootMarkers {
+			if _, err := os.Stat(filepath.Join(dir, m)); err == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return info.project
+		}
+		dir = parent
+	}
+}
+
+// serverStart is a server instance being started. The result is set
+// before done is closed.
+type serverStart struct {
+	done    chan struct{}
+	srv     *Server
+	err     error
+	stopped bool // stopped before it finished starting
 }
 
-func (info *ServerInfo) start(cfg *ClientConfig) (*Server, error) {
-	if info.srv != nil {
-		return info.srv, nil
+// start starts the server instance for project root if it's not running.
+// The function restarted is called after the server is restarted because
+// it exited, or when it's started again after restarting it was given up.
+// If the instance is already being started, it waits for it to start.
+func (info *ServerInfo) start(cfg *ClientConfig, root string, restarted func()) (*Server, error) {
+	info.mu.Lock()
+	if srv, ok := info.srvs[root]; ok {
+		info.mu.Unlock()
+		return srv, nil
 	}
+	if st, ok := info.starting[root]; ok {
+		info.mu.Unlock()
+		<-st.done
+		return st.srv, st.err
+	}
+	st := &serverStart{done: make(chan struct{})}
+	if info.starting == nil {
+		info.starting = make(map[string]*serverStart)
+	}
+	info.starting[root] = st
+	info.mu.Unlock()
 
+	// Starting the server and the initialize handshake can take a
+	// while, so they're done without holding info.mu, which would
+	// hold up the other server instances.
+	var (
+		srv *Server
+		err error
+	)
 	if len(info.Address) > 0 {
-		srv, err := dialServer(info.Server, cfg)
-		if err != nil {
-			return nil, err
+		srv, err = dialServer(info.Server, cfg, restarted)
+	} else {
+		srv, err = execServer(info.Server, cfg, restarted)
+	}
+	if err == nil {
+		srv.root = root
+		srv.mu.Lock()
+		srv.gaveUp = func() { info.remove(srv) }
+		srv.mu.Unlock()
+	}
+
+	info.mu.Lock()
+	delete(info.starting, root)
+	stopped := st.stopped
+	reopen := false
+	if err == nil && !stopped {
+		if info.srvs == nil {
+			info.srvs = make(map[string]*Server)
+		}
+		info.srvs[root] = srv
+		if info.gaveUp[root] {
+			// The files were opened in the previous instance.
+			delete(info.gaveUp, root)
+			reopen = true
 		}
-		info.srv = srv
+	}
+	info.mu.Unlock()
+
+	if err == nil && stopped {
+		srv.shutdown(context.Background())
+		srv, err = nil, fmt.Errorf("language server %v stopped while it was starting", info.ServerKey)
+	}
+	st.srv, st.err = srv, err
+	close(st.done)
+	if err != nil {
+		return nil, err
+	}
+	if reopen && restarted != nil {
+		go restarted()
+	}
+	return srv, nil
+}
+
+// remove removes the server instance srv, which is no longer restarted,
+// so that a new instance is started when it's needed.
+func (info *ServerInfo) remove(srv *Server) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
+	if info.srvs[srv.root] != srv {
+		return // already stopped
+	}
+	delete(info.srvs, srv.root)
+	if srv.idle != nil {
+		srv.idle.Stop()
+	}
+	if info.gaveUp == nil {
+		info.gaveUp = make(map[string]bool)
+	}
+	info.gaveUp[srv.root] = true
+}
+
+// running returns the running server instances sorted by project root.
+func (info *ServerInfo) running() []*Server {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
+	var srvs []*Server
+	for _, srv := range info.srvs {
+		srvs = append(srvs, srv)
+	}
+	sort.Slice(srvs, func(i, j int) bool {
+		return srvs[i].root < srvs[j].root
+	})
+	return srvs
+}
+
+// stop shuts down the server instance for project root, if it's running.
+// It'll be started again when it's needed. If reopen is true, the files
+// opened in it are reopened when it's started again; otherwise, the caller
+// is responsible for reopening them.
+func (info *ServerInfo) stop(ctx context.Context, root string, reopen bool) {
+	info.mu.Lock()
+	srv := info.srvs[root]
+	delete(info.srvs, root)
+	if srv != nil && srv.idle != nil {
+		srv.idle.Stop()
+	}
+	if st, ok := info.starting[root]; ok {
+		st.stopped = true // shut down by start once it's started
+	}
+	if reopen && srv != nil {
+		if info.gaveUp == nil {
+			info.gaveUp = make(map[string]bool)
+		}
+		info.gaveUp[root] = true
 	} else {
-		srv, err := execServer(info.Server, cfg)
-		if err != nil {
-			return nil, err
+		delete(info.gaveUp, root)
+	}
+	info.mu.Unlock()
+
+	if srv != nil {
+		srv.shutdown(ctx)
+	}
+}
+
+// stopAll shuts down all the running server instances, and the ones
+// being started once they're started. The files opened in them are
+// reopened when they're started again.
+func (info *ServerInfo) stopAll(ctx context.Context) {
+	info.mu.Lock()
+	for _, st := range info.starting {
+		st.stopped = true
+	}
+	info.mu.Unlock()
+
+	for _, srv := range info.running() {
+		info.stop(ctx, srv.root, true)
+	}
+}
+
+// restartable returns the project roots of the running server instances
+// and the ones that were given up on, sorted.
+func (info *ServerInfo) restartable() []string {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
+	var roots []string
+	for root := range info.srvs {
+		roots = append(roots, root)
+	}
+	for root := range info.gaveUp {
+		if _, ok := info.srvs[root]; !ok {
+			roots = append(roots, root)
 		}
-		info.srv = srv
 	}
-	return info.srv, nil
+	sort.Strings(roots)
+	return roots
+}
+
+// status returns the status of the running server instances, or the
+// status of the server if it's not running. The open documents are
+// not filled in.
+func (info *ServerInfo) status() []proxy.ServerStatus {
+	base := proxy.ServerStatus{
+		Key:     info.ServerKey,
+		Pattern: info.Pattern,
+		Command: info.Command,
+		Address: info.Address,
+	}
+	srvs := info.running()
+	if len(srvs) == 0 {
+		return []proxy.ServerStatus{base}
+	}
+	var result []proxy.ServerStatus
+	for _, srv := range srvs {
+		st := base
+		st.Root = srv.root
+		srv.mu.Lock()
+		st.Running = !srv.stopped
+		if srv.proc != nil {
+			st.PID = srv.proc.cmd.Process.Pid
+		}
+		st.Start = srv.start
+		st.Restarts = srv.restarts
+		srv.mu.Unlock()
+
+		if _, r := srv.Client.connection(); r != nil && r.ServerInfo != nil {
+			st.Name = r.ServerInfo.Name
+			st.Version = r.ServerInfo.Version
+		}
+		result = append(result, st)
+	}
+	return result
 }
 
 // ServerSet holds information about a set of LSP servers and connection to them,
 // which are created on-demand.
 type ServerSet struct {
 	Data       []*ServerInfo
-	diagWriter DiagnosticsWriter
+	diagWriter *diagMerger
+	msgWriter  MessageWriter
 	workspaces map[protocol.DocumentURI]*protocol.WorkspaceFolder // set of workspace folders
 	cfg        *config.Config
+	projects   map[string]*project // keyed by directory of files; nil if not in a project
+	mu         sync.Mutex          // guards Data, workspaces, cfg and projects
+
+	// File manager tracking the files opened in the servers.
+	// Set by NewFileManager.
+	fm *FileManager
 }
 
 // NewServerSet creates a new server set from config.
-func NewServerSet(cfg *config.Config, diagWriter DiagnosticsWriter) (*ServerSet, error) {
+// The msgWriter can be nil, in which case messages from the
+// LSP servers are logged to stderr.
+func NewServerSet(cfg *config.Config, diagWriter DiagnosticsWriter, msgWriter MessageWriter) (*ServerSet, error) {
 	workspaces := make(map[protocol.DocumentURI]*protocol.WorkspaceFolder)
 	if len(cfg.WorkspaceDirectories) > 0 {
 		folders, err := lsp.DirsToWorkspaceFolders(cfg.WorkspaceDirectories)
@@ -169,6 +806,57 @@ func NewServerSet(cfg *config.Config, diagWriter DiagnosticsWriter) (*ServerSet,
 		}
 	}
 
+	data, err := newServerInfos(cfg, openFiles(nil))
+	if err != nil {
+		return nil, err
+	}
+	return &ServerSet{
+		Data:       data,
+		diagWriter: newDiagMerger(diagWriter),
+		msgWriter:  msgWriter,
+		workspaces: workspaces,
+		cfg:        cfg,
+	}, nil
+}
+
+// serverFiles holds the open log and standard error files of servers,
+// keyed by filename, so that servers writing to the same file share it.
+type serverFiles struct {
+	loggers map[string]*log.Logger
+	stderrs map[string]*os.File
+}
+
+// openFiles returns the files already opened for servers infos.
+func openFiles(infos []*ServerInfo) *serverFiles {
+	files := &serverFiles{
+		loggers: make(map[string]*log.Logger),
+		stderrs: make(map[string]*os.File),
+	}
+	for _, info := range infos {
+		if info.LogFile != "" {
+			files.loggers[info.LogFile] = info.Logger
+		}
+		if info.stderr != nil {
+			files.stderrs[info.StderrFile] = info.stderr
+		}
+	}
+	return files
+}
+
+// closeStderrs closes the standard error files of servers infos,
+// except for the ones in keep.
+func closeStderrs(infos []*ServerInfo, keep map[string]*os.File) {
+	for _, info := range infos {
+		if info.stderr != nil && keep[info.StderrFile] != info.stderr {
+			info.stderr.Close() // may be closed already if shared
+		}
+	}
+}
+
+// newServerInfos returns the servers configured by cfg. The server log
+// and standard error files already open in files are reused, and the
+// ones opened are added to it.
+func newServerInfos(cfg *config.Config, files *serverFiles) ([]*ServerInfo, error) {
 	var data []*ServerInfo
 	for i, h := range cfg.FilenameHandlers {
 		cs, ok := cfg.Servers[h.ServerKey]
@@ -182,79 +870,561 @@ func NewServerSet(cfg *config.Config, diagWriter DiagnosticsWriter) (*ServerSet,
 		if err != nil {
 			return nil, err
 		}
-		var logger *log.Logger
-		if cs.LogFile != "" {
+		logger := files.loggers[cs.LogFile]
+		if cs.LogFile != "" && logger == nil {
 			f, err := os.Create(cs.LogFile)
 			if err != nil {
 				return nil, fmt.Errorf("could not create server %v LogFile: %v", h.ServerKey, err)
 			}
 			logger = log.New(f, "", log.LstdFlags)
+			files.loggers[cs.LogFile] = logger
+		}
+		stderr := files.stderrs[cs.StderrFile]
+		if cs.StderrFile != "" && stderr == nil {
+			stderr, err = os.Create(cs.StderrFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not create server %v StderrFile: %v", h.ServerKey, err)
+			}
+			files.stderrs[cs.StderrFile] = stderr
 		}
 		data = append(data, &ServerInfo{
 			Server:          cs,
 			FilenameHandler: &cfg.FilenameHandlers[i],
 			Re:              re,
 			Logger:          logger,
+			stderr:          stderr,
+			settings:        cs.Settings,
+			scopeSettings:   cs.ScopeSettings,
 		})
 	}
-	return &ServerSet{
-		Data:       data,
-		diagWriter: diagWriter,
-		workspaces: workspaces,
-		cfg:        cfg,
-	}, nil
+	return data, nil
 }
 
-func (ss *ServerSet) MatchFile(filename string) *ServerInfo {
-	for i, info := range ss.Data {
-		if info.Re.MatchString(filename) {
-			return ss.Data[i]
+// infos returns the configured servers.
+func (ss *ServerSet) infos() []*ServerInfo {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.Data
+}
+
+// config returns the current configuration.
+func (ss *ServerSet) config() *config.Config {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.cfg
+}
+
+// allInfos returns the configured servers, including the ones
+// configured by the project configuration files loaded so far.
+func (ss *ServerSet) allInfos() []*ServerInfo {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	infos := append([]*ServerInfo(nil), ss.Data...)
+	seen := make(map[*project]bool)
+	for _, p := range ss.projects {
+		if p == nil || seen[p] {
+			continue
+		}
+		seen[p] = true
+		for _, info := range p.infos {
+			if info.project != "" {
+				infos = append(infos, info)
+			}
+		}
+	}
+	return infos
+}
+
+// project holds the configuration of files within the directory
+// containing a project configuration file.
+type project struct {
+	cfg   *config.Config // configuration with the project overrides applied
+	infos []*ServerInfo  // servers handling files within the project
+}
+
+// project returns the project containing filename, or nil if it's not
+// within a project. Project configuration files are loaded once, until
+// the configuration is reloaded.
+func (ss *ServerSet) project(filename string) *project {
+	dir := filepath.Dir(filename)
+	ss.mu.Lock()
+	p, ok := ss.projects[dir]
+	ss.mu.Unlock()
+	if ok {
+		return p
+	}
+
+	pc, err := config.FindProject(dir)
+	if err != nil {
+		msg := fmt.Sprintf("failed to load project configuration: %v", err)
+		if ss.msgWriter != nil {
+			ss.msgWriter.WriteMessage("acme-lsp", protocol.Error, msg)
+		} else {
+			log.Print(msg)
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.projects == nil {
+		ss.projects = make(map[string]*project)
+	}
+	if pc != nil {
+		if q, ok := ss.projects[pc.Dir]; ok && q != nil {
+			p = q // already loaded for another directory
+		} else if p, err = ss.newProject(pc); err != nil {
+			msg := fmt.Sprintf("invalid project configuration: %v", err)
+			if ss.msgWriter != nil {
+				ss.msgWriter.WriteMessage("acme-lsp", protocol.Error, msg)
+			} else {
+				log.Print(msg)
+			}
+		} else {
+			ss.projects[pc.Dir] = p
+		}
+	}
+	ss.projects[dir] = p
+	return p
+}
+
+// newProject returns the project configured by pc. Servers that aren't
+// overridden by pc are shared with the files outside the project.
+// It must be called with ss.mu held.
+func (ss *ServerSet) newProject(pc *config.Project) (*project, error) {
+	cfg, err := pc.Apply(ss.cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Servers = make(map[string]*config.Server)
+	overridden := make(map[string]bool)
+	for key, cs := range ss.cfg.Servers {
+		cfg.Servers[key], overridden[key] = pc.Server(key, cs)
+	}
+
+	infos, err := newServerInfos(cfg, openFiles(ss.Data))
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[*ServerInfo]bool)
+	for i, info := range infos {
+		if !overridden[info.ServerKey] {
+			if base := matchingInfo(ss.Data, info, used); base != nil {
+				used[base] = true
+				infos[i] = base
+				continue
+			}
+		}
+		info.project = pc.Dir
+	}
+	return &project{cfg: cfg, infos: infos}, nil
+}
+
+// matchingInfo returns the first server in infos that isn't in used and
+// has the same server key and filename handler as info, or nil if there
+// is no such server.
+func matchingInfo(infos []*ServerInfo, info *ServerInfo, used map[*ServerInfo]bool) *ServerInfo {
+	for _, i := range infos {
+		if !used[i] && i.ServerKey == info.ServerKey && sameHandler(i.FilenameHandler, info.FilenameHandler) {
+			return i
 		}
 	}
 	return nil
 }
 
+// fileConfig returns the configuration of file name, which includes
+// the overrides of the project containing it.
+func (ss *ServerSet) fileConfig(name string) *config.Config {
+	if p := ss.project(name); p != nil {
+		return p.cfg
+	}
+	return ss.config()
+}
+
+// putConfig returns whether file name is formatted when Put is
+// executed, and the code actions run before formatting it.
+func (ss *ServerSet) putConfig(name string) (bool, []protocol.CodeActionKind) {
+	cfg := ss.fileConfig(name)
+	info := ss.MatchFile(name)
+	if info == nil {
+		return cfg.FormatOnPut, cfg.CodeActionsOnPut
+	}
+	// The handler of info may come from the configuration before it was
+	// reloaded, where the handler's Put-time actions may be different.
+	for i := range cfg.FilenameHandlers {
+		if h := &cfg.FilenameHandlers[i]; h.ServerKey == info.ServerKey && sameHandler(h, info.FilenameHandler) {
+			return cfg.OnPut(info.ServerKey, h)
+		}
+	}
+	return cfg.OnPut(info.ServerKey, nil)
+}
+
+// reload replaces the configuration with cfg. Running servers whose
+// configuration is unchanged keep running, and they're notified if their
+// Settings changed. The other servers, including the ones configured by
+// project configuration files, are shut down. It returns the servers
+// that were added or replaced, which are started on-demand.
+func (ss *ServerSet) reload(ctx context.Context, cfg *config.Config) ([]*ServerInfo, error) {
+	oldcfg := ss.config()
+	old := ss.infos()
+	all := ss.allInfos()
+	data, err := newServerInfos(cfg, openFiles(all))
+	if err != nil {
+		return nil, err
+	}
+
+	// Changes in these affect all servers.
+	keepAll := oldcfg.RootDirectory == cfg.RootDirectory &&
+		oldcfg.HideDiagnostics == cfg.HideDiagnostics &&
+		oldcfg.RPCTrace == cfg.RPCTrace &&
+		oldcfg.InterceptPut == cfg.InterceptPut &&
+		oldcfg.MessageRequestTimeout == cfg.MessageRequestTimeout &&
+		oldcfg.MessageRequestDefault == cfg.MessageRequestDefault
+
+	var (
+		added []*ServerInfo
+		kept  = make(map[*ServerInfo]bool)
+	)
+	for i, info := range data {
+		var o *ServerInfo
+		if keepAll {
+			for _, oi := range old {
+				if !kept[oi] && oi.ServerKey == info.ServerKey &&
+					sameHandler(oi.FilenameHandler, info.FilenameHandler) &&
+					sameServer(oi.Server, info.Server) {
+					o = oi
+					break
+				}
+			}
+		}
+		if o == nil {
+			added = append(added, info)
+			continue
+		}
+		kept[o] = true
+		data[i] = o
+		o.clearRoots() // root markers may have been added or removed
+
+		o.mu.Lock()
+		o.settings = info.settings
+		o.scopeSettings = info.scopeSettings
+		o.mu.Unlock()
+		for _, srv := range o.running() {
+			err := srv.Client.changeSettings(ctx, info.settings, info.scopeSettings)
+			if err != nil {
+				srv.Client.report(protocol.Error, "failed to change settings: %v", err)
+			}
+		}
+	}
+
+	ss.mu.Lock()
+	ss.Data = data
+	ss.cfg = cfg
+	ss.projects = nil
+	ss.mu.Unlock()
+
+	for _, info := range all {
+		if !kept[info] {
+			info.stopAll(ctx)
+		}
+	}
+	closeStderrs(all, openFiles(data).stderrs)
+	return added, nil
+}
+
+// sameServer returns true if the server configurations a and b are
+// the same, ignoring Settings, ScopeSettings and the Put-time actions,
+// which are read from the configuration when they're needed.
+func sameServer(a, b *config.Server) bool {
+	a1, b1 := *a, *b
+	a1.Settings, a1.ScopeSettings = nil, nil
+	b1.Settings, b1.ScopeSettings = nil, nil
+	a1.FormatOnPut, a1.CodeActionsOnPut = nil, nil
+	b1.FormatOnPut, b1.CodeActionsOnPut = nil, nil
+	return reflect.DeepEqual(a1, b1)
+}
+
+// sameHandler returns true if the filename handlers a and b are the
+// same, ignoring the Put-time actions.
+func sameHandler(a, b *config.FilenameHandler) bool {
+	a1, b1 := *a, *b
+	a1.FormatOnPut, a1.CodeActionsOnPut = nil, nil
+	b1.FormatOnPut, b1.CodeActionsOnPut = nil, nil
+	return a1 == b1
+}
+
+// MatchFile returns the server with the highest priority among the
+// servers that handle filename, or nil if there is no such server.
+func (ss *ServerSet) MatchFile(filename string) *ServerInfo {
+	if infos := ss.MatchFiles(filename); len(infos) > 0 {
+		return infos[0]
+	}
+	return nil
+}
+
+// MatchFiles returns the servers that handle filename, sorted by priority.
+// Servers with equal priority are in the order of the filename handlers in
+// the configuration. Only the first matching handler for each server key
+// is considered.
+func (ss *ServerSet) MatchFiles(filename string) []*ServerInfo {
+	all := ss.infos()
+	if p := ss.project(filename); p != nil {
+		all = p.infos
+	}
+	var infos []*ServerInfo
+	keys := make(map[string]bool)
+	for _, info := range all {
+		if !keys[info.ServerKey] && info.Re.MatchString(filename) {
+			keys[info.ServerKey] = true
+			infos = append(infos, info)
+		}
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Priority > infos[j].Priority
+	})
+	return infos
+}
+
+// handles returns true if filename is handled by the
+// server info instance for project root.
+func (ss *ServerSet) handles(info *ServerInfo, root string, filename string) bool {
+	for _, i := range ss.MatchFiles(filename) {
+		if i == info {
+			return info.root(filename) == root
+		}
+	}
+	return false
+}
+
 func (ss *ServerSet) ClientConfig(info *ServerInfo) *ClientConfig {
+	gcfg := ss.config()
+	cs := info.Server
+	info.mu.Lock()
+	if !reflect.DeepEqual(cs.Settings, info.settings) || !reflect.DeepEqual(cs.ScopeSettings, info.scopeSettings) {
+		// Settings changed by reload.
+		c := *cs
+		c.Settings = info.settings
+		c.ScopeSettings = info.scopeSettings
+		cs = &c
+	}
+	info.mu.Unlock()
+
+	root := gcfg.RootDirectory
+	if info.RootDirectory != "" {
+		root = expandVars(info.RootDirectory, map[string]string{"ROOT": root})
+	}
 	return &ClientConfig{
-		Server:          info.Server,
+		Server:          cs,
 		FilenameHandler: info.FilenameHandler,
-		RootDirectory:   ss.cfg.RootDirectory,
-		HideDiag:        ss.cfg.HideDiagnostics,
-		RPCTrace:        ss.cfg.RPCTrace,
+		RootDirectory:   root,
+		HideDiag:        gcfg.HideDiagnostics,
+		RPCTrace:        gcfg.RPCTrace,
+		WillSave:        gcfg.InterceptPut,
 		DiagWriter:      ss.diagWriter,
+		MsgWriter:       ss.msgWriter,
 		Workspaces:      ss.Workspaces(),
 		Logger:          info.Logger,
+		Stderr:          info.stderr,
+
+		MessageRequestTimeout: time.Duration(gcfg.MessageRequestTimeout),
+		MessageRequestDefault: gcfg.MessageRequestDefault,
+
+		WatchersChanged: ss.watchersChanged,
 	}
 }
 
+// StartForFile starts the server with the highest priority
+// that handles filename, if it's not running.
 func (ss *ServerSet) StartForFile(filename string) (*Server, bool, error) {
 	info := ss.MatchFile(filename)
 	if info == nil {
 		return nil, false, nil // unknown language server
 	}
-	srv, err := info.start(ss.ClientConfig(info))
+	srv, err := ss.start(info, info.root(filename))
 	if err != nil {
 		return nil, false, err
 	}
 	return srv, true, err
 }
 
+// StartAllForFile starts all the servers that handle filename.
+// The servers are sorted by priority.
+func (ss *ServerSet) StartAllForFile(filename string) ([]*Server, error) {
+	var srvs []*Server
+	for _, info := range ss.MatchFiles(filename) {
+		srv, err := ss.start(info, info.root(filename))
+		if err != nil {
+			return nil, err
+		}
+		srvs = append(srvs, srv)
+	}
+	return srvs, nil
+}
+
+// runningForFile returns the running servers that handle filename,
+// sorted by priority.
+func (ss *ServerSet) runningForFile(filename string) []*Server {
+	var srvs []*Server
+	for _, info := range ss.MatchFiles(filename) {
+		root := info.root(filename)
+		info.mu.Lock()
+		if srv, ok := info.srvs[root]; ok {
+			srvs = append(srvs, srv)
+		}
+		info.mu.Unlock()
+	}
+	return srvs
+}
+
+// StartForRequest starts the server with the highest priority that handles
+// filename and supports the request method according to its capabilities.
+// If none of the servers support the request, the server with the highest
+// priority is returned.
+func (ss *ServerSet) StartForRequest(filename string, method string) (*Server, bool, error) {
+	return ss.startFor(filename, func(cap *protocol.ServerCapabilities) bool {
+		return lsp.ServerProvides(cap, method)
+	})
+}
+
+// StartForCommand is like StartForRequest but it looks for a server
+// that can execute command.
+func (ss *ServerSet) StartForCommand(filename string, command string) (*Server, bool, error) {
+	return ss.startFor(filename, func(cap *protocol.ServerCapabilities) bool {
+		return lsp.ServerProvidesCommand(cap, command)
+	})
+}
+
+func (ss *ServerSet) startFor(filename string, supported func(*protocol.ServerCapabilities) bool) (*Server, bool, error) {
+	infos := ss.MatchFiles(filename)
+	if len(infos) == 0 {
+		return nil, false, nil // unknown language server
+	}
+	var first *Server
+	for _, info := range infos {
+		srv, err := ss.start(info, info.root(filename))
+		if err != nil {
+			return nil, false, err
+		}
+		if first == nil {
+			first = srv
+		}
+		if supported(srv.Client.capabilities(text.ToURI(filename))) {
+			return srv, true, nil
+		}
+	}
+	return first, true, nil
+}
+
+// start starts the server info for project root if it's not running.
+// If the server has an IdleTimeout, the time it's considered idle is reset.
+func (ss *ServerSet) start(info *ServerInfo, root string) (*Server, error) {
+	cfg := ss.ClientConfig(info)
+	cfg.DiagWriter = ss.diagWriter.writer(fmt.Sprintf("%v %v", info.ServerKey, root))
+	if root != "" {
+		folders, err := lsp.DirsToWorkspaceFolders([]string{root})
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootDirectory = root
+		cfg.Workspaces = folders
+	}
+	srv, err := info.start(cfg, root, func() {
+		if ss.fm == nil {
+			return
+		}
+		if err := ss.fm.reopen(info, root); err != nil {
+			log.Printf("failed to reopen files after server restart: %v", err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if timeout := time.Duration(info.IdleTimeout); timeout > 0 {
+		info.mu.Lock()
+		srv.lastUsed = time.Now()
+		if srv.idle == nil {
+			srv.idle = time.AfterFunc(timeout, func() {
+				ss.stopIfIdle(info, srv)
+			})
+		} else {
+			srv.idle.Reset(timeout)
+		}
+		info.mu.Unlock()
+	}
+	return srv, nil
+}
+
+// stopIfIdle shuts down the server instance srv started by info if it has
+// no open files and hasn't been used for IdleTimeout. It'll be started
+// again when it's needed.
+func (ss *ServerSet) stopIfIdle(info *ServerInfo, srv *Server) {
+	timeout := time.Duration(info.IdleTimeout)
+	if ss.fm != nil && len(ss.fm.openFiles(info, srv.root)) > 0 {
+		info.mu.Lock()
+		srv.idle.Reset(timeout)
+		info.mu.Unlock()
+		return
+	}
+
+	info.mu.Lock()
+	if info.srvs[srv.root] != srv || time.Since(srv.lastUsed) < timeout {
+		// Stopped or used since the timer fired.
+		info.mu.Unlock()
+		return
+	}
+	delete(info.srvs, srv.root)
+	info.mu.Unlock()
+
+	msg := fmt.Sprintf("idle for %v; shutting down", timeout)
+	if ss.msgWriter != nil {
+		ss.msgWriter.WriteMessage(srv.Client.serverName(), protocol.Info, msg)
+	} else if Verbose {
+		log.Printf("language server %v: %v", srv.Client.serverName(), msg)
+	}
+	srv.shutdown(context.Background())
+}
+
+// ServerMatch returns a proxy.Server that sends requests to the servers
+// handling filename.
 func (ss *ServerSet) ServerMatch(ctx context.Context, filename string) (proxy.Server, bool, error) {
-	srv, found, err := ss.StartForFile(filename)
+	_, found, err := ss.StartForFile(filename)
 	if err != nil || !found {
 		return nil, found, err
 	}
-	return srv.Client, found, err
+	return &proxyServer{ss: ss, fm: ss.fm}, found, err
 }
 
+// CloseAll shuts down all the running servers.
 func (ss *ServerSet) CloseAll() {
-	for _, info := range ss.Data {
-		info.srv.Close()
+	var wg sync.WaitGroup
+	for _, info := range ss.allInfos() {
+		wg.Add(1)
+		go func(info *ServerInfo) {
+			defer wg.Done()
+			info.stopAll(context.Background())
+		}(info)
 	}
+	wg.Wait()
+	closeStderrs(ss.allInfos(), nil)
+}
+
+// withKey returns the servers with the given key in the configuration.
+func (ss *ServerSet) withKey(key string) ([]*ServerInfo, error) {
+	var infos []*ServerInfo
+	for _, info := range ss.allInfos() {
+		if info.ServerKey == key {
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("no server with key %q", key)
+	}
+	return infos, nil
 }
 
 func (ss *ServerSet) PrintTo(w io.Writer) {
-	for _, info := range ss.Data {
+	for _, info := range ss.infos() {
 		if len(info.Address) > 0 {
 			fmt.Fprintf(w, "%v %v\n", info.Re, info.Address)
 		} else {
@@ -263,9 +1433,14 @@ func (ss *ServerSet) PrintTo(w io.Writer) {
 	}
 }
 
+// forEach calls f for each server that isn't started per project root,
+// starting the server if necessary.
 func (ss *ServerSet) forEach(f func(*Client) error) error {
-	for _, info := range ss.Data {
-		srv, err := info.start(ss.ClientConfig(info))
+	for _, info := range ss.infos() {
+		if len(info.RootMarkers) > 0 {
+			continue // project root is the only workspace folder
+		}
+		srv, err := ss.start(info, "")
 		if err != nil {
 			return err
 		}
@@ -279,10 +1454,13 @@ func (ss *ServerSet) forEach(f func(*Client) error) error {
 
 // Workspaces returns a sorted list of current workspace directories.
 func (ss *ServerSet) Workspaces() []protocol.WorkspaceFolder {
+	ss.mu.Lock()
 	var folders []protocol.WorkspaceFolder
-	for i := range ss.workspaces {
-		folders = append(folders, *ss.workspaces[i])
+	for _, d := range ss.workspaces {
+		folders = append(folders, *d)
 	}
+	ss.mu.Unlock()
+
 	sort.Slice(folders, func(i, j int) bool {
 		return folders[i].URI < folders[j].URI
 	})
@@ -302,13 +1480,29 @@ func (ss *ServerSet) DidChangeWorkspaceFolders(ctx context.Context, added, remov
 	if err != nil {
 		return err
 	}
-	for i := range added {
-		d := &added[i]
-		ss.workspaces[d.URI] = d
+	ss.mu.Lock()
+	for _, d := range added {
+		d := d // not shared with the caller
+		ss.workspaces[d.URI] = &d
 	}
 	for _, d := range removed {
 		delete(ss.workspaces, d.URI)
 	}
+	ss.mu.Unlock()
+
+	for _, d := range removed {
+		ss.diagWriter.DropDiagnostics(d.URI)
+	}
+	folders := ss.Workspaces()
+	for _, info := range ss.infos() {
+		if len(info.RootMarkers) > 0 {
+			continue
+		}
+		for _, srv := range info.running() {
+			srv.Client.setWorkspaces(folders)
+		}
+	}
+	ss.watchersChanged()
 	return nil
 }
 
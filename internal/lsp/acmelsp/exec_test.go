@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/jsonrpc2"
 	"github.com/fhs/acme-lsp/internal/lsp"
 	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
 	"github.com/fhs/acme-lsp/internal/lsp/protocol"
@@ -62,7 +67,7 @@ func TestServerSetWorkspaces(t *testing.T) {
 			},
 		},
 	}
-	ss, err := NewServerSet(cfg, &mockDiagosticsWriter{ioutil.Discard})
+	ss, err := NewServerSet(cfg, &mockDiagosticsWriter{ioutil.Discard}, nil)
 	if err != nil {
 		t.Fatalf("failed to create server set: %v", err)
 	}
@@ -103,6 +108,46 @@ func TestServerSetWorkspaces(t *testing.T) {
 	}
 }
 
+func TestServerSetWorkspacesConcurrent(t *testing.T) {
+	cfg := &config.Config{
+		File: config.File{
+			RootDirectory: "/",
+		},
+	}
+	ss, err := NewServerSet(cfg, &mockDiagosticsWriter{ioutil.Discard}, nil)
+	if err != nil {
+		t.Fatalf("failed to create server set: %v", err)
+	}
+	defer ss.CloseAll()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			folders, err := lsp.DirsToWorkspaceFolders([]string{fmt.Sprintf("/path/to/mod%v", i)})
+			if err != nil {
+				t.Errorf("DirsToWorkspaceFolders failed: %v", err)
+				return
+			}
+			if err := ss.DidChangeWorkspaceFolders(context.Background(), folders, nil); err != nil {
+				t.Errorf("DidChangeWorkspaceFolders failed: %v", err)
+			}
+			ss.Workspaces()
+		}(i)
+	}
+	wg.Wait()
+
+	got := ss.Workspaces()
+	if len(got) != 4 {
+		t.Fatalf("workspaces are %v; want 4 of them", got)
+	}
+	got[0].Name = "changed"
+	if ss.Workspaces()[0].Name == "changed" {
+		t.Errorf("changing the returned workspace folders changed the server set")
+	}
+}
+
 type mockDiagosticsWriter struct {
 	io.Writer
 }
@@ -116,3 +161,610 @@ func (dw *mockDiagosticsWriter) WriteDiagnostics(params *protocol.PublishDiagnos
 		fmt.Fprintf(dw, "%v: %v\n", lsp.LocationLink(loc), diag.Message)
 	}
 }
+
+func (dw *mockDiagosticsWriter) DropDiagnostics(uri protocol.DocumentURI) {}
+
+func TestRestartPolicy(t *testing.T) {
+	p := newRestartPolicy(&config.Server{
+		MaxRestarts:     3,
+		RestartBackoff:  config.Duration(time.Second),
+		CrashLoopWindow: config.Duration(time.Minute),
+	})
+	start := time.Now()
+	for i, tc := range []struct {
+		exit  time.Duration // time of exit since start
+		delay time.Duration
+		ok    bool
+	}{
+		{0, time.Second, true},
+		{10 * time.Second, 2 * time.Second, true},
+		{20 * time.Second, 4 * time.Second, true},
+		{30 * time.Second, 0, false},
+		{70 * time.Second, 4 * time.Second, true}, // first exit is outside window
+		{200 * time.Second, time.Second, true},
+	} {
+		delay, ok := p.next(start.Add(tc.exit))
+		if delay != tc.delay || ok != tc.ok {
+			t.Errorf("%v: next returned (%v, %v); want (%v, %v)", i, delay, ok, tc.delay, tc.ok)
+		}
+	}
+
+	p = newRestartPolicy(&config.Server{MaxRestarts: -1})
+	if _, ok := p.next(start); ok {
+		t.Errorf("server with negative MaxRestarts is restarted")
+	}
+}
+
+func TestServerInfoRemove(t *testing.T) {
+	srv := &Server{root: "/mod"}
+	other := &Server{root: "/mod"}
+	info := &ServerInfo{
+		srvs: map[string]*Server{"/mod": srv},
+	}
+	info.remove(other)
+	if info.srvs["/mod"] != srv {
+		t.Fatalf("removing a server that was already replaced removed the running instance")
+	}
+	info.remove(srv)
+	if len(info.srvs) != 0 {
+		t.Errorf("server instances after remove are %v; want none", info.srvs)
+	}
+	if !info.gaveUp["/mod"] {
+		t.Errorf("files of removed server instance won't be reopened when it's started again")
+	}
+
+	info.srvs["/other"] = other
+	got := info.restartable()
+	if want := []string{"/mod", "/other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restartable roots are %q; want %q", got, want)
+	}
+}
+
+// initServer is a LSP server that only responds to the initialize
+// request once it's released, and to the other requests with null.
+type initServer struct {
+	jsonrpc2.EmptyHandler
+	conn    net.Conn
+	release chan struct{}
+}
+
+func serveInit(conn net.Conn) *initServer {
+	s := &initServer{conn: conn, release: make(chan struct{})}
+	rpc := jsonrpc2.NewConn(jsonrpc2.NewHeaderStream(conn, conn))
+	rpc.AddHandler(s)
+	go rpc.Run(context.Background())
+	return s
+}
+
+func (s *initServer) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered bool) bool {
+	switch {
+	case r.Method == "exit":
+		s.conn.Close()
+	case r.IsNotify():
+	case r.Method == "initialize":
+		r.Parallel()
+		<-s.release
+		r.Reply(ctx, &protocol.InitializeResult{}, nil)
+	default:
+		r.Reply(ctx, nil, nil)
+	}
+	return true
+}
+
+func TestServerInfoStartUnlocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-lsp-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "server.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	servers := make(chan *initServer)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			servers <- serveInit(conn)
+		}
+	}()
+
+	info := &ServerInfo{
+		Server: &config.Server{
+			Address:     "unix:" + socket,
+			MaxRestarts: -1,
+		},
+		FilenameHandler: &config.FilenameHandler{},
+	}
+	cfg := &ClientConfig{
+		Server:          info.Server,
+		FilenameHandler: info.FilenameHandler,
+		DiagWriter:      &mockDiagosticsWriter{ioutil.Discard},
+	}
+	type result struct {
+		srv *Server
+		err error
+	}
+	start := func(root string) <-chan result {
+		c := make(chan result, 1)
+		go func() {
+			srv, err := info.start(cfg, root, nil)
+			c <- result{srv, err}
+		}()
+		return c
+	}
+
+	first, second := start("/mod"), start("/mod")
+	s := <-servers
+
+	// The server is being initialized, which doesn't hold up the
+	// other uses of info.
+	running := make(chan []*Server)
+	go func() { running <- info.running() }()
+	select {
+	case srvs := <-running:
+		if len(srvs) != 0 {
+			t.Errorf("running servers are %v while starting; want none", srvs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("info.mu is held while the server is initialized")
+	}
+
+	close(s.release)
+	r1, r2 := <-first, <-second
+	if r1.err != nil || r2.err != nil {
+		t.Fatalf("start failed: %v, %v", r1.err, r2.err)
+	}
+	if r1.srv != r2.srv || info.srvs["/mod"] != r1.srv {
+		t.Errorf("concurrent starts for the same root started more than one instance")
+	}
+
+	// Stopped while it's being started.
+	other := start("/other")
+	s = <-servers
+	info.stopAll(context.Background())
+	close(s.release)
+	if r := <-other; r.err == nil {
+		t.Errorf("start succeeded for server stopped while it was starting")
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if len(info.starting) != 0 || len(info.srvs) != 0 {
+		t.Errorf("server instances after stopping are %v, and %v being started; want none", info.srvs, info.starting)
+	}
+}
+
+func TestNewServerInfosStderr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-lsp-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{
+		File: config.File{
+			Servers: map[string]*config.Server{
+				"pyls": {
+					Command:    []string{"pyls"},
+					StderrFile: filepath.Join(dir, "pyls.stderr"),
+				},
+			},
+			FilenameHandlers: []config.FilenameHandler{
+				{Pattern: `\.py$`, ServerKey: "pyls"},
+				{Pattern: `\.pyi$`, ServerKey: "pyls"},
+			},
+		},
+	}
+	infos, err := newServerInfos(cfg, openFiles(nil))
+	if err != nil {
+		t.Fatalf("newServerInfos failed: %v", err)
+	}
+	if infos[0].stderr == nil || infos[0].stderr != infos[1].stderr {
+		t.Fatalf("servers writing to the same StderrFile don't share it")
+	}
+	if _, err := infos[0].stderr.WriteString("error\n"); err != nil {
+		t.Fatalf("write to StderrFile failed: %v", err)
+	}
+
+	again, err := newServerInfos(cfg, openFiles(infos))
+	if err != nil {
+		t.Fatalf("newServerInfos failed: %v", err)
+	}
+	if again[0].stderr != infos[0].stderr {
+		t.Errorf("StderrFile is opened again instead of being reused")
+	}
+	b, err := ioutil.ReadFile(cfg.Servers["pyls"].StderrFile)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if got, want := string(b), "error\n"; got != want {
+		t.Errorf("StderrFile contains %q; want %q", got, want)
+	}
+
+	closeStderrs(infos, nil)
+	if _, err := infos[0].stderr.WriteString("error\n"); err == nil {
+		t.Errorf("StderrFile isn't closed")
+	}
+}
+
+func TestServerInfoRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-lsp-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mod := filepath.Join(dir, "mod")
+	if err := os.MkdirAll(filepath.Join(mod, "pkg"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	// Use an unusual root marker in case the temporary directory is within a project.
+	if err := ioutil.WriteFile(filepath.Join(mod, "acme-lsp-test.mod"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	info := &ServerInfo{
+		Server: &config.Server{
+			RootMarkers: []string{"acme-lsp-test.mod", "acme-lsp-test.git"},
+		},
+	}
+	for _, tc := range []struct {
+		filename, root string
+	}{
+		{filepath.Join(mod, "main.go"), mod},
+		{filepath.Join(mod, "pkg", "pkg.go"), mod},
+		{filepath.Join(dir, "other.go"), ""},
+	} {
+		if got := info.root(tc.filename); got != tc.root {
+			t.Errorf("root of %v is %q; want %q", tc.filename, got, tc.root)
+		}
+	}
+
+	// Roots are cached until the configuration is reloaded.
+	if err := ioutil.WriteFile(filepath.Join(dir, "acme-lsp-test.git"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	other := filepath.Join(dir, "other.go")
+	if got := info.root(other); got != "" {
+		t.Errorf("cached root of %v is %q; want empty", other, got)
+	}
+	info.clearRoots()
+	if got := info.root(other); got != dir {
+		t.Errorf("root of %v after clearing cache is %q; want %q", other, got, dir)
+	}
+
+	info.RootMarkers = nil
+	if got := info.root(filepath.Join(mod, "main.go")); got != "" {
+		t.Errorf("root without RootMarkers is %q; want empty", got)
+	}
+}
+
+func TestServerSetMatchFiles(t *testing.T) {
+	cfg := &config.Config{
+		File: config.File{
+			Servers: map[string]*config.Server{
+				"gopls": {
+					Command: []string{"gopls"},
+				},
+				"lint": {
+					Command:  []string{"golangci-lint-langserver"},
+					Priority: -1,
+				},
+				"mod": {
+					Command:  []string{"modls"},
+					Priority: 1,
+				},
+			},
+			FilenameHandlers: []config.FilenameHandler{
+				{Pattern: `\.go$`, ServerKey: "lint"},
+				{Pattern: `\.go$`, ServerKey: "gopls"},
+				{Pattern: `go\.mod$`, ServerKey: "gopls"},
+				{Pattern: `\.(go|mod)$`, ServerKey: "mod"},
+			},
+		},
+	}
+	ss, err := NewServerSet(cfg, &mockDiagosticsWriter{ioutil.Discard}, nil)
+	if err != nil {
+		t.Fatalf("failed to create server set: %v", err)
+	}
+	for _, tc := range []struct {
+		filename string
+		keys     []string
+	}{
+		{"/a/main.go", []string{"mod", "gopls", "lint"}},
+		{"/a/go.mod", []string{"mod", "gopls"}},
+		{"/a/main.py", nil},
+	} {
+		var keys []string
+		for _, info := range ss.MatchFiles(tc.filename) {
+			keys = append(keys, info.ServerKey)
+		}
+		if !cmp.Equal(keys, tc.keys) {
+			t.Errorf("servers for %v are %v; want %v", tc.filename, keys, tc.keys)
+		}
+	}
+	if info := ss.MatchFile("/a/main.go"); info == nil || info.ServerKey != "mod" {
+		t.Errorf("MatchFile returned %v; want server mod", info)
+	}
+}
+
+func TestServerSetRunningForFile(t *testing.T) {
+	cfg := &config.Config{
+		File: config.File{
+			Servers: map[string]*config.Server{
+				"gopls": {
+					Command: []string{"acme-lsp-test-nonexistent-gopls"},
+				},
+				"lint": {
+					Command:  []string{"acme-lsp-test-nonexistent-lint"},
+					Priority: -1,
+				},
+			},
+			FilenameHandlers: []config.FilenameHandler{
+				{Pattern: `\.go$`, ServerKey: "lint"},
+				{Pattern: `\.go$`, ServerKey: "gopls"},
+			},
+		},
+	}
+	ss, err := NewServerSet(cfg, &mockDiagosticsWriter{ioutil.Discard}, nil)
+	if err != nil {
+		t.Fatalf("failed to create server set: %v", err)
+	}
+	fm := &FileManager{
+		ss:   ss,
+		wins: map[string]struct{}{"/a/main.go": {}},
+	}
+	ss.fm = fm
+
+	// Closing a file doesn't start the servers that aren't running.
+	if err := fm.didClose("/a/main.go"); err != nil {
+		t.Fatalf("didClose failed: %v", err)
+	}
+	if _, ok := fm.wins["/a/main.go"]; ok {
+		t.Errorf("closed file is still open in file manager")
+	}
+	if srvs := ss.runningForFile("/a/main.go"); len(srvs) != 0 {
+		t.Errorf("running servers after closing file are %v; want none", srvs)
+	}
+
+	gopls := ss.MatchFile("/a/main.go")
+	srv := &Server{root: gopls.root("/a/main.go")}
+	gopls.srvs = map[string]*Server{srv.root: srv}
+	if srvs := ss.runningForFile("/a/main.go"); len(srvs) != 1 || srvs[0] != srv {
+		t.Errorf("running servers for /a/main.go are %v; want %v", srvs, srv)
+	}
+	if srvs := ss.runningForFile("/a/main.py"); len(srvs) != 0 {
+		t.Errorf("running servers for /a/main.py are %v; want none", srvs)
+	}
+}
+
+func TestServerSetReload(t *testing.T) {
+	newConfig := func(goplsFlag string, lintSettings map[string]interface{}) *config.Config {
+		return &config.Config{
+			File: config.File{
+				Servers: map[string]*config.Server{
+					"gopls": {
+						Command: []string{"gopls", goplsFlag},
+					},
+					"lint": {
+						Command:  []string{"golangci-lint-langserver"},
+						Settings: lintSettings,
+					},
+				},
+				FilenameHandlers: []config.FilenameHandler{
+					{Pattern: `\.go$`, ServerKey: "gopls"},
+					{Pattern: `\.go$`, ServerKey: "lint"},
+				},
+			},
+		}
+	}
+	ss, err := NewServerSet(newConfig("-remote=auto", nil), &mockDiagosticsWriter{ioutil.Discard}, nil)
+	if err != nil {
+		t.Fatalf("failed to create server set: %v", err)
+	}
+	gopls, lint := ss.Data[0], ss.Data[1]
+
+	lintSettings := map[string]interface{}{"command": []string{"golangci-lint", "run"}}
+	added, err := ss.reload(context.Background(), newConfig("-rpc.trace", lintSettings))
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(added) != 1 || added[0].ServerKey != "gopls" || added[0] == gopls {
+		t.Errorf("reload added %v; want new gopls server", added)
+	}
+	if got := ss.infos(); len(got) != 2 || got[0] != added[0] || got[1] != lint {
+		t.Errorf("servers after reload are %v; want new gopls and old lint", got)
+	}
+	cfg := ss.ClientConfig(lint)
+	if !cmp.Equal(cfg.Settings, lintSettings) {
+		t.Errorf("lint settings after reload are %v; want %v", cfg.Settings, lintSettings)
+	}
+	if lint.Server.Settings != nil {
+		t.Errorf("reload modified configuration of lint")
+	}
+}
+
+func TestServerSetPutConfig(t *testing.T) {
+	newConfig := func(formatTests bool) *config.Config {
+		return &config.Config{
+			File: config.File{
+				FormatOnPut: true,
+				Servers: map[string]*config.Server{
+					"gopls": {
+						Command: []string{"gopls"},
+					},
+				},
+				FilenameHandlers: []config.FilenameHandler{
+					{Pattern: `_test\.go$`, ServerKey: "gopls", FormatOnPut: &formatTests},
+					{Pattern: `\.go$`, ServerKey: "gopls"},
+				},
+			},
+		}
+	}
+	ss, err := NewServerSet(newConfig(false), &mockDiagosticsWriter{ioutil.Discard}, nil)
+	if err != nil {
+		t.Fatalf("failed to create server set: %v", err)
+	}
+	if format, _ := ss.putConfig("/a/main.go"); !format {
+		t.Errorf("main.go is not formatted on Put")
+	}
+	if format, _ := ss.putConfig("/a/main_test.go"); format {
+		t.Errorf("main_test.go is formatted on Put")
+	}
+
+	// The servers are kept, since only the Put-time actions changed.
+	if _, err := ss.reload(context.Background(), newConfig(true)); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if format, _ := ss.putConfig("/a/main_test.go"); !format {
+		t.Errorf("main_test.go is not formatted on Put after reload")
+	}
+}
+
+func TestServerSetProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-lsp-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	proj := filepath.Join(dir, "proj")
+	if err := os.MkdirAll(filepath.Join(proj, "pkg"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	projectConfig := `
+FormatOnPut = false
+
+[Servers.gopls.Options]
+buildFlags = ["-tags=integration"]
+
+[[FilenameHandlers]]
+Pattern = "\\.tmpl$"
+ServerKey = "gopls"
+`
+	if err := ioutil.WriteFile(filepath.Join(proj, config.ProjectFilename), []byte(projectConfig), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	cfg := &config.Config{
+		File: config.File{
+			FormatOnPut: true,
+			Servers: map[string]*config.Server{
+				"gopls": {
+					Command: []string{"gopls"},
+				},
+				"lint": {
+					Command:  []string{"golangci-lint-langserver"},
+					Priority: -1,
+				},
+			},
+			FilenameHandlers: []config.FilenameHandler{
+				{Pattern: `\.go$`, ServerKey: "gopls"},
+				{Pattern: `\.go$`, ServerKey: "lint"},
+			},
+		},
+	}
+	ss, err := NewServerSet(cfg, &mockDiagosticsWriter{ioutil.Discard}, nil)
+	if err != nil {
+		t.Fatalf("failed to create server set: %v", err)
+	}
+	gopls, lint := ss.Data[0], ss.Data[1]
+
+	outside := filepath.Join(dir, "main.go")
+	if infos := ss.MatchFiles(outside); len(infos) != 2 || infos[0] != gopls || infos[1] != lint {
+		t.Errorf("servers for %v are %v; want the configured servers", outside, infos)
+	}
+	if !ss.fileConfig(outside).FormatOnPut {
+		t.Errorf("FormatOnPut is false outside of project")
+	}
+
+	inside := filepath.Join(proj, "pkg", "pkg.go")
+	infos := ss.MatchFiles(inside)
+	if len(infos) != 2 || infos[0] == gopls || infos[1] != lint {
+		t.Fatalf("servers for %v are %v; want project gopls and lint", inside, infos)
+	}
+	pgopls := infos[0]
+	if got, want := pgopls.Options, map[string]interface{}{"buildFlags": []interface{}{"-tags=integration"}}; !cmp.Equal(got, want) {
+		t.Errorf("project gopls options are %v; want %v", got, want)
+	}
+	if got := pgopls.root(inside); got != proj {
+		t.Errorf("root of project gopls is %q; want %q", got, proj)
+	}
+	if gopls.Options != nil {
+		t.Errorf("project modified configuration of gopls")
+	}
+	if ss.fileConfig(inside).FormatOnPut {
+		t.Errorf("FormatOnPut is not overridden by project")
+	}
+	if infos := ss.MatchFiles(filepath.Join(proj, "main.go")); len(infos) != 2 || infos[0] != pgopls {
+		t.Errorf("files in the same project are handled by different servers")
+	}
+	tmpl := filepath.Join(proj, "page.tmpl")
+	if infos := ss.MatchFiles(tmpl); len(infos) != 1 || infos[0].ServerKey != "gopls" {
+		t.Errorf("servers for %v are %v; want gopls", tmpl, infos)
+	}
+	if got := len(ss.allInfos()); got != 4 {
+		t.Errorf("got %v servers including project servers; want 4", got)
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	os.Setenv("ACME_LSP_TEST_VENV", "/home/gopher/venv")
+	defer os.Unsetenv("ACME_LSP_TEST_VENV")
+
+	for _, tc := range []struct {
+		s, want string
+	}{
+		{"gopls", "gopls"},
+		{"$ACME_LSP_TEST_VENV/bin/pyls", "/home/gopher/venv/bin/pyls"},
+		{"VIRTUAL_ENV=${ACME_LSP_TEST_VENV}", "VIRTUAL_ENV=/home/gopher/venv"},
+		{"$ROOT/build", "/src/proj/build"},
+		{"-logfile=$ACME_LSP_TEST_UNSET", "-logfile="},
+	} {
+		if got := expandVars(tc.s, map[string]string{"ROOT": "/src/proj"}); got != tc.want {
+			t.Errorf("expandVars(%q) is %q; want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestDialAddress(t *testing.T) {
+	for _, tc := range []struct {
+		addr, network, address string
+	}{
+		{"localhost:4389", "tcp", "localhost:4389"},
+		{"tcp:localhost:4389", "tcp", "localhost:4389"},
+		{"unix:/tmp/gopls.sock", "unix", "/tmp/gopls.sock"},
+		{"[::1]:4389", "tcp", "[::1]:4389"},
+	} {
+		network, address := dialAddress(tc.addr)
+		if network != tc.network || address != tc.address {
+			t.Errorf("dialAddress(%q) is %q, %q; want %q, %q", tc.addr, network, address, tc.network, tc.address)
+		}
+	}
+}
+
+func TestSharedSocket(t *testing.T) {
+	args := []string{"gopls", "serve", "-listen=unix;$SOCKET"}
+	s := sharedSocket(args, nil, "")
+	if filepath.Dir(s) != filepath.Clean(os.TempDir()) {
+		t.Errorf("socket %q is not in %q", s, os.TempDir())
+	}
+	if s2 := sharedSocket(append([]string(nil), args...), nil, ""); s2 != s {
+		t.Errorf("socket for same command is %q; want %q", s2, s)
+	}
+	for _, s2 := range []string{
+		sharedSocket(args, []string{"GOFLAGS=-mod=vendor"}, ""),
+		sharedSocket(args, nil, "/src/proj"),
+		sharedSocket(args[:2], nil, ""),
+	} {
+		if s2 == s {
+			t.Errorf("different servers share socket %q", s)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package acmelsp
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/fhs/acme-lsp/internal/acme"
@@ -21,20 +24,29 @@ import (
 // because having the ctl file open prevents del event from
 // being delivered to acme/log file.
 type FileManager struct {
-	ss   *ServerSet
-	wins map[string]struct{} // set of open files
-	mu   sync.Mutex
+	ss       *ServerSet
+	wins     map[string]struct{} // set of open files
+	putWins  map[int]bool        // windows where Put is intercepted
+	prepared map[int]bool        // windows prepared by beforePut for the Put being executed
+	cfg      *config.Config      // changed by Reload
+	mu       sync.Mutex
 
-	cfg *config.Config
+	// Signals that the directories watched for changes must be updated.
+	watchUpdate chan struct{}
 }
 
 // NewFileManager creates a new file manager, initialized with files currently open in acme.
 func NewFileManager(ss *ServerSet, cfg *config.Config) (*FileManager, error) {
 	fm := &FileManager{
-		ss:   ss,
-		wins: make(map[string]struct{}),
-		cfg:  cfg,
+		ss:       ss,
+		wins:     make(map[string]struct{}),
+		putWins:  make(map[int]bool),
+		prepared: make(map[int]bool),
+		cfg:      cfg,
+
+		watchUpdate: make(chan struct{}, 1),
 	}
+	ss.fm = fm
 
 	wins, err := acme.Windows()
 	if err != nil {
@@ -51,6 +63,8 @@ func NewFileManager(ss *ServerSet, cfg *config.Config) (*FileManager, error) {
 
 // Run watches for files opened, closed, saved, or refreshed in acme
 // and tells LSP server about it. It also formats files when it's saved.
+// Files changed outside acme are watched in the background (see
+// watchFiles).
 func (fm *FileManager) Run() {
 	alog, err := acme.Log()
 	if err != nil {
@@ -59,6 +73,9 @@ func (fm *FileManager) Run() {
 	}
 	defer alog.Close()
 
+	go fm.watchFiles()
+	fm.updateWatches()
+
 	for {
 		ev, err := alog.Read()
 		if err != nil {
@@ -82,8 +99,11 @@ func (fm *FileManager) Run() {
 			if err := fm.didSave(ev.ID, ev.Name); err != nil {
 				log.Printf("didSave failed in file manager: %v", err)
 			}
-			if fm.cfg.FormatOnPut {
-				if err := fm.format(ev.ID, ev.Name); err != nil && Verbose {
+			if fm.wasPrepared(ev.ID) {
+				continue // already formatted before Put
+			}
+			if format, actions := fm.ss.putConfig(ev.Name); format {
+				if err := fm.format(ev.ID, ev.Name, actions); err != nil && Verbose {
 					log.Printf("Format failed in file manager: %v", err)
 				}
 			}
@@ -91,12 +111,27 @@ func (fm *FileManager) Run() {
 	}
 }
 
-func (fm *FileManager) withClient(winid int, name string, f func(*Client, *acmeutil.Win) error) error {
-	s, found, err := fm.ss.StartForFile(name)
-	if err != nil {
-		return err
+// servers returns the servers that handle file name, starting them if
+// they're not running, or nil if the file isn't open. Starting the
+// servers can take a while, so it's done without holding fm.mu, which
+// would hold up the other file events; the caller must check again that
+// the file is open once it's holding fm.mu.
+func (fm *FileManager) servers(name string) ([]*Server, error) {
+	fm.mu.Lock()
+	_, ok := fm.wins[name]
+	fm.mu.Unlock()
+	if !ok {
+		return nil, nil // Unknown language server.
 	}
-	if !found {
+	return fm.ss.StartAllForFile(name)
+}
+
+// forClients calls f for the client of each server in srvs, with the
+// window winid opened if winid is not negative. All the clients are
+// called even if f returns an error for one of them, and the errors
+// are combined.
+func forClients(winid int, srvs []*Server, f func(*Client, *acmeutil.Win) error) error {
+	if len(srvs) == 0 {
 		return nil // Unknown language server.
 	}
 
@@ -109,28 +144,90 @@ func (fm *FileManager) withClient(winid int, name string, f func(*Client, *acmeu
 		defer w.CloseFiles()
 		win = w
 	}
-	return f(s.Client, win)
+	var errs errorList
+	for _, s := range srvs {
+		if err := f(s.Client, win); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
 }
 
-func (fm *FileManager) didOpen(winid int, name string) error {
-	return fm.withClient(winid, name, func(c *Client, w *acmeutil.Win) error {
-		fm.mu.Lock()
-		defer fm.mu.Unlock()
+// errorList combines the errors of operations that are done even if
+// some of them fail (e.g. sending a notification to multiple servers).
+type errorList []error
 
-		if _, ok := fm.wins[name]; ok {
-			return fmt.Errorf("file already open in file manager: %v", name)
+func (l errorList) Error() string {
+	var b strings.Builder
+	for i, err := range l {
+		if i > 0 {
+			b.WriteString("; ")
 		}
-		fm.wins[name] = struct{}{}
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// err returns the list as an error, or nil if the list is empty.
+// A single error is returned as is.
+func (l errorList) err() error {
+	switch len(l) {
+	case 0:
+		return nil
+	case 1:
+		return l[0]
+	}
+	return l
+}
 
+func (fm *FileManager) didOpen(winid int, name string) error {
+	if fm.ss.MatchFile(name) == nil {
+		return nil // Unknown language server.
+	}
+
+	// Starting the servers can take a while, so it's done without
+	// holding fm.mu, which would hold up the other file events.
+	srvs, err := fm.ss.StartAllForFile(name)
+	if err != nil {
+		return err
+	}
+
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if _, ok := fm.wins[name]; ok {
+		return fmt.Errorf("file already open in file manager: %v", name)
+	}
+	fm.ss.diagWriter.openDocument(text.ToURI(name))
+	err = forClients(winid, srvs, func(c *Client, w *acmeutil.Win) error {
 		b, err := w.ReadAll("body")
 		if err != nil {
 			return err
 		}
-		return lsp.DidOpen(context.Background(), c, name, c.cfg.FilenameHandler.LanguageID, b)
+		err = lsp.DidOpen(context.Background(), c, name, c.cfg.FilenameHandler.LanguageID, b)
+		if err != nil {
+			return err
+		}
+		pullDiagnostics(c, name)
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	// Only track the file once the servers know about it, so that
+	// changes aren't sent for a document that was never opened.
+	fm.wins[name] = struct{}{}
+	if fm.cfg.InterceptPut {
+		fm.watchPut(winid)
+	}
+	return nil
 }
 
 func (fm *FileManager) didClose(name string) error {
+	// The file isn't open in the servers that aren't running, and
+	// they're not started just to close it.
+	srvs := fm.ss.runningForFile(name)
+
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
@@ -139,24 +236,40 @@ func (fm *FileManager) didClose(name string) error {
 	}
 	delete(fm.wins, name)
 
-	return fm.withClient(-1, name, func(c *Client, _ *acmeutil.Win) error {
-		return lsp.DidClose(context.Background(), c, name)
+	err := forClients(-1, srvs, func(c *Client, _ *acmeutil.Win) error {
+		err := lsp.DidClose(context.Background(), c, name)
+		c.forgetDiagnostics(text.ToURI(name))
+		return err
 	})
+	// Drop the diagnostics after the servers are told about it, so that
+	// the ones published in the meantime are dropped too.
+	fm.ss.diagWriter.closeDocument(text.ToURI(name))
+	return err
 }
 
 func (fm *FileManager) didChange(winid int, name string) error {
+	srvs, err := fm.servers(name)
+	if err != nil {
+		return err
+	}
+
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
 	if _, ok := fm.wins[name]; !ok {
-		return nil // Unknown language server.
+		return nil // Closed while starting the servers.
 	}
-	return fm.withClient(winid, name, func(c *Client, w *acmeutil.Win) error {
+	return forClients(winid, srvs, func(c *Client, w *acmeutil.Win) error {
 		b, err := w.ReadAll("body")
 		if err != nil {
 			return err
 		}
-		return lsp.DidChange(context.Background(), c, name, b)
+		err = lsp.DidChange(context.Background(), c, name, b)
+		if err != nil {
+			return err
+		}
+		pullDiagnostics(c, name)
+		return nil
 	})
 }
 
@@ -176,13 +289,18 @@ func (fm *FileManager) DidChange(winid int) error {
 }
 
 func (fm *FileManager) didSave(winid int, name string) error {
+	srvs, err := fm.servers(name)
+	if err != nil {
+		return err
+	}
+
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
 	if _, ok := fm.wins[name]; !ok {
-		return nil // Unknown language server.
+		return nil // Closed while starting the servers.
 	}
-	return fm.withClient(winid, name, func(c *Client, w *acmeutil.Win) error {
+	return forClients(winid, srvs, func(c *Client, w *acmeutil.Win) error {
 		b, err := w.ReadAll("body")
 		if err != nil {
 			return err
@@ -193,21 +311,195 @@ func (fm *FileManager) didSave(winid int, name string) error {
 		if err != nil {
 			return err
 		}
-		return lsp.DidSave(context.Background(), c, name)
+		var saved []byte
+		if opt := lsp.ServerTextDocumentSyncOptions(c.capabilities(text.ToURI(name))); opt.Save != nil && opt.Save.IncludeText {
+			saved = b
+		}
+		err = lsp.DidSave(context.Background(), c, name, saved)
+		if err != nil {
+			return err
+		}
+		pullDiagnostics(c, name)
+		return nil
 	})
 }
 
-func (fm *FileManager) format(winid int, name string) error {
+// openFiles returns the sorted list of open files handled by the
+// server info instance for project root.
+func (fm *FileManager) openFiles(info *ServerInfo, root string) []string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	var names []string
+	for name := range fm.wins {
+		if fm.ss.handles(info, root, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reopen opens the files handled by the server info instance for
+// project root again, which is necessary after the server is restarted.
+// All the files are opened even if some of them fail to open, and the
+// errors are combined.
+func (fm *FileManager) reopen(info *ServerInfo, root string) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	srv, err := fm.ss.start(info, root)
+	if err != nil {
+		return err
+	}
+	c := srv.Client
+
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	var errs errorList
+	for _, wi := range wins {
+		if _, ok := fm.wins[wi.Name]; !ok || !fm.ss.handles(info, root, wi.Name) {
+			continue
+		}
+		if err := reopenWin(c, wi.ID, wi.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+// reopenWin opens file name, which is being edited in window winid,
+// in the server of client c.
+func reopenWin(c *Client, winid int, name string) error {
+	w, err := acmeutil.OpenWin(winid)
+	if err != nil {
+		return err
+	}
+	b, err := w.ReadAll("body")
+	w.CloseFiles()
+	if err != nil {
+		return err
+	}
+	err = lsp.DidOpen(context.Background(), c, name, c.cfg.FilenameHandler.LanguageID, b)
+	if err != nil {
+		return err
+	}
+	pullDiagnostics(c, name)
+	return nil
+}
+
+func (fm *FileManager) format(winid int, name string, actions []protocol.CodeActionKind) error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
 	if _, ok := fm.wins[name]; !ok {
 		return nil // Unknown language server.
 	}
-	return fm.withClient(winid, name, func(c *Client, w *acmeutil.Win) error {
-		doc := &protocol.TextDocumentIdentifier{
-			URI: text.ToURI(name),
+	w, err := acmeutil.OpenWin(winid)
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+
+	doc := &protocol.TextDocumentIdentifier{
+		URI: text.ToURI(name),
+	}
+	cfg := fm.ss.fileConfig(name)
+	srvs, err := fm.ss.StartAllForFile(name)
+	if err != nil {
+		return err
+	}
+	for _, s := range srvs {
+		if d := s.Client.errorDiagnostic(doc.URI, cfg.SkipFormatOnErrors); d != nil {
+			fm.warn("not formatting %v: %v:%v: %v", name, name, d.Range.Start.Line+1, d.Message)
+			return nil
+		}
+	}
+	check := func(before, after []byte) error {
+		err := checkFormatChange(cfg.MaxFormatChange, before, after)
+		if err != nil {
+			fm.warn("not formatting %v: %v", name, err)
+		}
+		return err
+	}
+	// The code actions are checked against the capabilities of the
+	// server the codeAction request is sent to. The other requests are
+	// routed to the server that supports them.
+	srv, found, err := fm.ss.StartForRequest(name, "textDocument/codeAction")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil // Unknown language server.
+	}
+	server := &codeActionServer{
+		proxyServer: &proxyServer{ss: fm.ss, fm: fm},
+		srv:         srv,
+	}
+	return codeActionAndFormat(context.Background(), server, doc, w, actions, check)
+}
+
+// codeActionServer is a FormatServer that sends the codeAction request,
+// and the initialize request used to check which code actions are
+// supported, to server srv.
+type codeActionServer struct {
+	*proxyServer
+	srv *Server
+}
+
+func (s *codeActionServer) InitializeResult(ctx context.Context, doc *protocol.TextDocumentIdentifier) (*protocol.InitializeResult, error) {
+	return s.srv.Client.InitializeResult(ctx, doc)
+}
+
+func (s *codeActionServer) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	return s.srv.Client.CodeAction(ctx, params)
+}
+
+// warn writes a warning to the messages window, or logs it if there
+// is no messages window.
+func (fm *FileManager) warn(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if fm.ss.msgWriter != nil {
+		fm.ss.msgWriter.WriteMessage("acme-lsp", protocol.Warning, msg)
+		return
+	}
+	log.Print(msg)
+}
+
+// minFormatChange is the number of lines formatting may always change,
+// regardless of the MaxFormatChange fraction, which would otherwise
+// prevent formatting small files.
+const minFormatChange = 10
+
+// checkFormatChange returns an error, which includes the diff, if
+// formatting text before into after changes more than minFormatChange
+// lines and more than fraction max of the lines.
+func checkFormatChange(max float64, before, after []byte) error {
+	deleted, inserted, diff := text.LineDiff(before, after)
+	changed := deleted
+	if inserted > changed {
+		changed = inserted
+	}
+	total := bytes.Count(before, []byte("\n"))
+	if len(before) > 0 && before[len(before)-1] != '\n' {
+		total++
+	}
+	if changed > minFormatChange && float64(changed) > max*float64(total) {
+		return fmt.Errorf("edits change %v of %v lines:\n%v", changed, total, diff)
+	}
+	return nil
+}
+
+// pullDiagnostics requests diagnostics for file name in the background
+// if the server supports pull model diagnostics. The results are written
+// to the same DiagnosticsWriter used for published diagnostics.
+func pullDiagnostics(c *Client, name string) {
+	go func() {
+		err := c.pullDiagnostics(context.Background(), text.ToURI(name))
+		if err != nil && Verbose {
+			log.Printf("failed to pull diagnostics for %v: %v", name, err)
 		}
-		return CodeActionAndFormat(context.Background(), c, doc, w, fm.cfg.CodeActionsOnPut)
-	})
+	}()
 }
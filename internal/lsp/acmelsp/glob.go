@@ -0,0 +1,80 @@
+package acmelsp
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// globRegexp converts a glob pattern used in LSP document filters and file
+// system watchers to a regular expression that matches slash-separated
+// paths. In the pattern, * matches zero or more characters in a path
+// segment, ? matches one character in a path segment, ** matches any
+// number of path segments, including none, {a,b} matches a or b, and
+// [a-z] matches a character in a range ([!a-z] negates the range).
+// The pattern matches the path if it matches its last elements, so a
+// pattern without a slash matches the last element of the path.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?:^|/)")
+	depth := 0 // nesting of braces
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '{':
+			depth++
+			sb.WriteString("(?:")
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("unmatched '}' in glob pattern %q", pattern)
+			}
+			depth--
+			sb.WriteString(")")
+		case ',':
+			if depth > 0 {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+		case '[':
+			j := strings.IndexByte(pattern[i+1:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("unmatched '[' in glob pattern %q", pattern)
+			}
+			class := pattern[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += j + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unmatched '{' in glob pattern %q", pattern)
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// matchGlob returns true if filename matches the glob pattern compiled
+// by globRegexp into re. A nil re matches nothing.
+func matchGlob(re *regexp.Regexp, filename string) bool {
+	return re != nil && re.MatchString(filepath.ToSlash(filename))
+}
@@ -0,0 +1,34 @@
+package acmelsp
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, filename string
+		want              bool
+	}{
+		{"*.go", "/home/gopher/hello/main.go", true},
+		{"*.go", "/home/gopher/hello/main.go/x", false},
+		{"*.{ts,js}", "/src/app.js", true},
+		{"*.{ts,js}", "/src/app.jsx", false},
+		{"**/*.go", "/home/gopher/hello/main.go", true},
+		{"**/go.mod", "/home/gopher/hello/go.mod", true},
+		{"**/go.mod", "/home/gopher/hello/xgo.mod", false},
+		{"**/*.{go,mod}", "/home/gopher/hello/go.mod", true},
+		{"/home/**/*.py", "/home/gopher/a/b/c.py", true},
+		{"/home/**/*.py", "/usr/home/c.py", false},
+		{"src/*.c", "/work/src/main.c", true},
+		{"src/*.c", "/work/src/lib/main.c", false},
+		{"src/**", "/work/src/lib/main.c", true},
+		{"file?.txt", "/a/file1.txt", true},
+		{"file?.txt", "/a/file10.txt", false},
+		{"[a-c]*.h", "/a/b.h", true},
+		{"[!a-c]*.h", "/a/b.h", false},
+		{"{a.go", "/a.go", false},
+	} {
+		re, _ := globRegexp(tc.pattern) // nil if invalid, which matches nothing
+		if got := matchGlob(re, tc.filename); got != tc.want {
+			t.Errorf("matchGlob of %q and %q is %v; want %v", tc.pattern, tc.filename, got, tc.want)
+		}
+	}
+}
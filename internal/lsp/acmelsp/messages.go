@@ -0,0 +1,220 @@
+package acmelsp
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fhs/acme-lsp/internal/acmeutil"
+	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+)
+
+// MessageWriter writes messages meant for the user, such as the ones
+// sent by LSP servers in window/showMessage and window/logMessage
+// notifications.
+type MessageWriter interface {
+	// WriteMessage writes message of type typ. The source is usually
+	// the name of the LSP server that sent the message.
+	WriteMessage(source string, typ protocol.MessageType, message string)
+
+	// WriteProgress writes a message about the progress of work done
+	// by source, such as its beginning or end. Unlike WriteMessage,
+	// it's not subject to a minimum message level.
+	WriteProgress(source string, message string)
+}
+
+// msgWin implements MessageWriter.
+// It appends messages to an acme window.
+// It will create the messages window on-demand, recreating it if necessary.
+type msgWin struct {
+	name string // window name
+	*acmeutil.Win
+	level       protocol.MessageType // minimum level of messages shown
+	pendingChan chan struct{}        // pending has new entries
+
+	pending   []string // lines not yet written to the window
+	pendingMu sync.Mutex
+
+	dead bool // window has been closed
+	mu   sync.Mutex
+}
+
+func newMsgWin(name string, level protocol.MessageType) *msgWin {
+	return &msgWin{
+		name:        name,
+		level:       level,
+		pendingChan: make(chan struct{}, 1),
+		dead:        true,
+	}
+}
+
+func (mw *msgWin) restart() error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	if !mw.dead {
+		return nil
+	}
+	w, err := acmeutil.Hijack(mw.name)
+	if err != nil {
+		w, err = acmeutil.NewWin()
+		if err != nil {
+			return err
+		}
+		w.Name(mw.name)
+	}
+	// A window left behind by a previous acme-lsp instance may not
+	// have the command in its tag.
+	if tag, err := w.ReadAll("tag"); err != nil || !hasWord(tag, "Clear") {
+		w.Write("tag", []byte(" Clear "))
+	}
+	mw.Win = w
+	mw.dead = false
+
+	go func() {
+		defer func() {
+			mw.mu.Lock()
+			w.Del(true)
+			w.CloseFiles()
+			if mw.Win == w {
+				mw.dead = true
+			}
+			mw.mu.Unlock()
+		}()
+
+		for ev := range w.EventChan() {
+			if ev == nil {
+				return
+			}
+			switch ev.C2 {
+			case 'x', 'X': // execute
+				switch string(ev.Text) {
+				case "Del":
+					return
+				case "Clear":
+					mw.mu.Lock()
+					w.Clear()
+					w.Ctl("clean")
+					mw.mu.Unlock()
+					continue
+				}
+			}
+			w.WriteEvent(ev)
+		}
+	}()
+	return nil
+}
+
+// hasWord returns true if word is one of the space separated words in text.
+func hasWord(text []byte, word string) bool {
+	for _, f := range strings.Fields(string(text)) {
+		if f == word {
+			return true
+		}
+	}
+	return false
+}
+
+// write appends lines to the window, creating it if necessary. If the
+// lines can't be written, e.g. because the window was deleted without
+// us noticing, the window is reopened and they're written again.
+func (mw *msgWin) write(lines []string) error {
+	if err := mw.restart(); err != nil {
+		return err
+	}
+	err := mw.writeBody(lines)
+	if err == nil {
+		return nil
+	}
+	log.Printf("failed to write to %v window: %v; reopening it", mw.name, err)
+	mw.mu.Lock()
+	mw.dead = true
+	mw.mu.Unlock()
+	if err := mw.restart(); err != nil {
+		return err
+	}
+	return mw.writeBody(lines)
+}
+
+func (mw *msgWin) writeBody(lines []string) error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	for _, l := range lines {
+		if _, err := mw.Write("body", []byte(l)); err != nil {
+			return err
+		}
+	}
+	return mw.Ctl("clean")
+}
+
+// WriteMessage implements MessageWriter.
+// It doesn't block waiting for the acme window to be updated.
+func (mw *msgWin) WriteMessage(source string, typ protocol.MessageType, message string) {
+	if typ > mw.level {
+		return
+	}
+	mw.add(source, fmt.Sprint(typ), message)
+}
+
+// WriteProgress implements MessageWriter.
+// It doesn't block waiting for the acme window to be updated.
+func (mw *msgWin) WriteProgress(source string, message string) {
+	mw.add(source, "Progress", message)
+}
+
+// add adds a line for message from source, labeled with kind, to the lines
+// pending to be written to the window.
+func (mw *msgWin) add(source, kind, message string) {
+	message = strings.TrimRight(message, "\n")
+	message = strings.Replace(message, "\n", "\n\t", -1)
+	line := fmt.Sprintf("%v %v %v: %v\n", time.Now().Format("2006/01/02 15:04:05"), source, kind, message)
+
+	mw.pendingMu.Lock()
+	mw.pending = append(mw.pending, line)
+	mw.pendingMu.Unlock()
+
+	select {
+	case mw.pendingChan <- struct{}{}:
+	default: // already notified
+	}
+}
+
+// takePending returns the lines not yet written to the window, and
+// forgets them.
+func (mw *msgWin) takePending() []string {
+	mw.pendingMu.Lock()
+	defer mw.pendingMu.Unlock()
+
+	lines := mw.pending
+	mw.pending = nil
+	return lines
+}
+
+// NewMessageWriter returns a MessageWriter that appends messages to the
+// /LSP/Messages acme window. Messages less severe than cfg.MessageLevel
+// are discarded.
+func NewMessageWriter(cfg *config.Config) MessageWriter {
+	level := protocol.ParseMessageType(cfg.MessageLevel)
+	if level == 0 {
+		level = protocol.Warning
+	}
+	mw := newMsgWin("/LSP/Messages", level)
+
+	go func() {
+		for range mw.pendingChan {
+			lines := mw.takePending()
+			if err := mw.write(lines); err != nil {
+				// Don't lose the messages.
+				log.Printf("failed to write to %v window: %v", mw.name, err)
+				for _, l := range lines {
+					log.Print(l)
+				}
+			}
+		}
+	}()
+	return mw
+}
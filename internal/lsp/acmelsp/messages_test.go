@@ -0,0 +1,54 @@
+package acmelsp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+)
+
+func TestMsgWinWriteMessage(t *testing.T) {
+	mw := newMsgWin("/LSP/Messages", protocol.Warning)
+	mw.WriteMessage("gopls", protocol.Error, "build failed\nmissing go.sum entry\n")
+	mw.WriteMessage("gopls", protocol.Info, "loading packages")
+	mw.WriteMessage("pyls", protocol.Warning, "unused import")
+
+	select {
+	case <-mw.pendingChan:
+	default:
+		t.Errorf("window isn't notified of pending messages")
+	}
+	lines := mw.takePending()
+	want := []string{
+		"gopls Error: build failed\n\tmissing go.sum entry\n",
+		"pyls Warning: unused import\n",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("pending lines are %q; want %q", lines, want)
+	}
+	for i, l := range lines {
+		// Skip the date and time.
+		if f := strings.SplitN(l, " ", 3); len(f) != 3 || f[2] != want[i] {
+			t.Errorf("line %v is %q; want it to end with %q", i, l, want[i])
+		}
+	}
+	if lines := mw.takePending(); len(lines) != 0 {
+		t.Errorf("pending lines after they're taken are %q; want none", lines)
+	}
+}
+
+func TestHasWord(t *testing.T) {
+	for _, tc := range []struct {
+		tag  string
+		want bool
+	}{
+		{"/LSP/Messages Del Snarf | Look Clear ", true},
+		{"/LSP/Messages Del Snarf | Look ", false},
+		{"/LSP/Messages Del Snarf | Look Clearly", false},
+		{"", false},
+	} {
+		if got := hasWord([]byte(tc.tag), "Clear"); got != tc.want {
+			t.Errorf("hasWord(%q, Clear) is %v; want %v", tc.tag, got, tc.want)
+		}
+	}
+}
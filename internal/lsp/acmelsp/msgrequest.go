@@ -0,0 +1,116 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fhs/acme-lsp/internal/acmeutil"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+)
+
+// messageRequest shows the window/showMessageRequest params sent by the
+// LSP server named source in a new acme window, where the actions are
+// listed one per line. It waits until the user executes one of the actions,
+// the window is deleted, timeout expires, or ctx is done. In the last three
+// cases, the action titled def is chosen, or no action if there is no such
+// action. A zero timeout means wait forever.
+func messageRequest(ctx context.Context, source string, params *protocol.ShowMessageRequestParams, timeout time.Duration, def string) (*protocol.MessageActionItem, error) {
+	if len(params.Actions) == 0 {
+		return nil, nil
+	}
+	findAction := func(title string) *protocol.MessageActionItem {
+		title = strings.TrimSpace(title)
+		for i := range params.Actions {
+			if params.Actions[i].Title == title {
+				return &params.Actions[i]
+			}
+		}
+		return nil
+	}
+
+	w, err := acmeutil.NewWin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		w.Del(true)
+		w.CloseFiles()
+	}()
+	w.Name("/LSP/%v/Request", source)
+	body := w.FileReadWriter("body")
+	fmt.Fprintf(body, "%v: %v\n\n", params.Type, params.Message)
+	for _, a := range params.Actions {
+		fmt.Fprintf(body, "\t%v\n", a.Title)
+	}
+	if def != "" && findAction(def) != nil {
+		fmt.Fprintf(body, "\nExecute one of the actions above. Defaults to %q.\n", def)
+	} else {
+		fmt.Fprintf(body, "\nExecute one of the actions above.\n")
+	}
+	w.Ctl("clean")
+
+	chosen := make(chan *protocol.MessageActionItem, 1)
+	go func() {
+		defer close(chosen)
+
+		for ev := range w.EventChan() {
+			if ev == nil {
+				return
+			}
+			switch ev.C2 {
+			case 'x', 'X': // execute
+				if string(ev.Text) == "Del" {
+					return
+				}
+				a := findAction(string(ev.Text))
+				if a == nil && ev.C2 == 'X' {
+					// The action title may contain spaces, so
+					// look at the whole line.
+					b, err := w.ReadAll("body")
+					if err == nil {
+						a = findAction(lineAt([]rune(string(b)), ev.OrigQ0))
+					}
+				}
+				if a != nil {
+					chosen <- a
+					return
+				}
+			}
+			w.WriteEvent(ev)
+		}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case a := <-chosen:
+		if a != nil {
+			return a, nil
+		}
+	case <-expired:
+	case <-ctx.Done():
+	}
+	return findAction(def), nil
+}
+
+// lineAt returns the line containing the rune offset q in text.
+func lineAt(text []rune, q int) string {
+	if q < 0 || q > len(text) {
+		return ""
+	}
+	i := q
+	for i > 0 && text[i-1] != '\n' {
+		i--
+	}
+	j := q
+	for j < len(text) && text[j] != '\n' {
+		j++
+	}
+	return string(text[i:j])
+}
@@ -25,20 +25,29 @@ func (s *proxyServer) WorkspaceFolders(context.Context) ([]protocol.WorkspaceFol
 	return s.ss.Workspaces(), nil
 }
 
+// InitializeResult returns the initialize result of the server with the
+// highest priority that handles the document.
 func (s *proxyServer) InitializeResult(ctx context.Context, params *protocol.TextDocumentIdentifier) (*protocol.InitializeResult, error) {
-	srv, err := serverForURI(s.ss, params.URI)
+	srv, err := serverForURI(s.ss, params.URI, "initialize")
 	if err != nil {
 		return nil, fmt.Errorf("InitializeResult: %v", err)
 	}
 	return srv.Client.InitializeResult(ctx, params)
 }
 
+// DidChange sends the change to all the servers that handle the document.
 func (s *proxyServer) DidChange(ctx context.Context, params *protocol.DidChangeTextDocumentParams) error {
-	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	srvs, err := s.ss.StartAllForFile(text.ToPath(params.TextDocument.URI))
 	if err != nil {
 		return fmt.Errorf("DidChange: %v", err)
 	}
-	return srv.Client.DidChange(ctx, params)
+	var errs errorList
+	for _, srv := range srvs {
+		if err := srv.Client.DidChange(ctx, params); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
 }
 
 func (s *proxyServer) DidChangeWorkspaceFolders(ctx context.Context, params *protocol.DidChangeWorkspaceFoldersParams) error {
@@ -46,7 +55,7 @@ func (s *proxyServer) DidChangeWorkspaceFolders(ctx context.Context, params *pro
 }
 
 func (s *proxyServer) Completion(ctx context.Context, params *protocol.CompletionParams) (*protocol.CompletionList, error) {
-	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI, "textDocument/completion")
 	if err != nil {
 		return nil, fmt.Errorf("Completion: %v", err)
 	}
@@ -54,7 +63,7 @@ func (s *proxyServer) Completion(ctx context.Context, params *protocol.Completio
 }
 
 func (s *proxyServer) Definition(ctx context.Context, params *protocol.DefinitionParams) ([]protocol.Location, error) {
-	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI, "textDocument/definition")
 	if err != nil {
 		return nil, fmt.Errorf("Definition: %v", err)
 	}
@@ -62,7 +71,7 @@ func (s *proxyServer) Definition(ctx context.Context, params *protocol.Definitio
 }
 
 func (s *proxyServer) Formatting(ctx context.Context, params *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
-	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocument.URI, "textDocument/formatting")
 	if err != nil {
 		return nil, fmt.Errorf("Formatting: %v", err)
 	}
@@ -70,7 +79,7 @@ func (s *proxyServer) Formatting(ctx context.Context, params *protocol.DocumentF
 }
 
 func (s *proxyServer) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
-	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocument.URI, "textDocument/codeAction")
 	if err != nil {
 		return nil, fmt.Errorf("CodeAction: %v", err)
 	}
@@ -78,7 +87,11 @@ func (s *proxyServer) CodeAction(ctx context.Context, params *protocol.CodeActio
 }
 
 func (s *proxyServer) ExecuteCommandOnDocument(ctx context.Context, params *proxy.ExecuteCommandOnDocumentParams) (interface{}, error) {
-	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	filename := text.ToPath(params.TextDocument.URI)
+	srv, found, err := s.ss.StartForCommand(filename, params.ExecuteCommandParams.Command)
+	if err == nil {
+		err = checkFound(params.TextDocument.URI, found)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("ExecuteCommandOnDocument: %v", err)
 	}
@@ -86,7 +99,7 @@ func (s *proxyServer) ExecuteCommandOnDocument(ctx context.Context, params *prox
 }
 
 func (s *proxyServer) Hover(ctx context.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
-	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI, "textDocument/hover")
 	if err != nil {
 		return nil, fmt.Errorf("Hover: %v", err)
 	}
@@ -94,7 +107,7 @@ func (s *proxyServer) Hover(ctx context.Context, params *protocol.HoverParams) (
 }
 
 func (s *proxyServer) Implementation(ctx context.Context, params *protocol.ImplementationParams) ([]protocol.Location, error) {
-	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI, "textDocument/implementation")
 	if err != nil {
 		return nil, fmt.Errorf("Implementation: %v", err)
 	}
@@ -102,7 +115,7 @@ func (s *proxyServer) Implementation(ctx context.Context, params *protocol.Imple
 }
 
 func (s *proxyServer) References(ctx context.Context, params *protocol.ReferenceParams) ([]protocol.Location, error) {
-	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI, "textDocument/references")
 	if err != nil {
 		return nil, fmt.Errorf("References: %v", err)
 	}
@@ -110,7 +123,7 @@ func (s *proxyServer) References(ctx context.Context, params *protocol.Reference
 }
 
 func (s *proxyServer) Rename(ctx context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
-	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocument.URI, "textDocument/rename")
 	if err != nil {
 		return nil, fmt.Errorf("Rename: %v", err)
 	}
@@ -118,7 +131,7 @@ func (s *proxyServer) Rename(ctx context.Context, params *protocol.RenameParams)
 }
 
 func (s *proxyServer) SignatureHelp(ctx context.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
-	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI, "textDocument/signatureHelp")
 	if err != nil {
 		return nil, fmt.Errorf("SignatureHelp: %v", err)
 	}
@@ -126,7 +139,7 @@ func (s *proxyServer) SignatureHelp(ctx context.Context, params *protocol.Signat
 }
 
 func (s *proxyServer) DocumentSymbol(ctx context.Context, params *protocol.DocumentSymbolParams) ([]protocol.DocumentSymbol, error) {
-	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocument.URI, "textDocument/documentSymbol")
 	if err != nil {
 		return nil, fmt.Errorf("DocumentSymbol: %v", err)
 	}
@@ -134,25 +147,117 @@ func (s *proxyServer) DocumentSymbol(ctx context.Context, params *protocol.Docum
 }
 
 func (s *proxyServer) TypeDefinition(ctx context.Context, params *protocol.TypeDefinitionParams) ([]protocol.Location, error) {
-	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI, "textDocument/typeDefinition")
 	if err != nil {
 		return nil, fmt.Errorf("TypeDefinition: %v", err)
 	}
 	return srv.Client.TypeDefinition(ctx, params)
 }
 
-func serverForURI(ss *ServerSet, uri protocol.DocumentURI) (*Server, error) {
-	filename := text.ToPath(uri)
-	srv, found, err := ss.StartForFile(filename)
-	if !found {
-		return nil, fmt.Errorf("unknown language server for URI %q", uri)
+// WorkDoneProgress returns the progress being reported by all the
+// running servers. It doesn't start any servers.
+func (s *proxyServer) WorkDoneProgress(ctx context.Context) ([]proxy.WorkDoneProgressStatus, error) {
+	var result []proxy.WorkDoneProgressStatus
+	for _, info := range s.ss.allInfos() {
+		for _, srv := range info.running() {
+			p, err := srv.Client.WorkDoneProgress(ctx)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, p...)
+		}
+	}
+	return result, nil
+}
+
+// Servers returns the status of all the configured servers.
+func (s *proxyServer) Servers(ctx context.Context) ([]proxy.ServerStatus, error) {
+	var result []proxy.ServerStatus
+	for _, info := range s.ss.allInfos() {
+		if info.project != "" && len(info.running()) == 0 {
+			continue // shown when it's running
+		}
+		for _, st := range info.status() {
+			if st.Running && s.fm != nil {
+				st.OpenDocuments = s.fm.openFiles(info, st.Root)
+			}
+			result = append(result, st)
+		}
+	}
+	return result, nil
+}
+
+// RestartServer stops the servers with the given key and starts them
+// again, reopening the files handled by them. For servers started per
+// project root, only the running instances and the ones that were given
+// up on after terminating too often are restarted.
+func (s *proxyServer) RestartServer(ctx context.Context, params *proxy.ServerKeyParams) error {
+	infos, err := s.ss.withKey(params.Key)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		roots := info.restartable()
+		if len(roots) == 0 && len(info.RootMarkers) == 0 && info.project == "" {
+			roots = []string{""}
+		}
+		for _, root := range roots {
+			info.stop(ctx, root, false)
+			if _, err := s.ss.start(info, root); err != nil {
+				return err
+			}
+			if s.fm != nil {
+				if err := s.fm.reopen(info, root); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// StopServer stops the servers with the given key. They're started
+// again when they're needed, and the files handled by them are reopened.
+func (s *proxyServer) StopServer(ctx context.Context, params *proxy.ServerKeyParams) error {
+	infos, err := s.ss.withKey(params.Key)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		info.stopAll(ctx)
+	}
+	return nil
+}
+
+// Reload reloads the configuration file.
+func (s *proxyServer) Reload(ctx context.Context) error {
+	if s.fm == nil {
+		return fmt.Errorf("configuration can't be reloaded without a file manager")
 	}
+	return s.fm.Reload(ctx)
+}
+
+// serverForURI returns the server with the highest priority that handles
+// the document uri and supports the request method.
+func serverForURI(ss *ServerSet, uri protocol.DocumentURI, method string) (*Server, error) {
+	filename := text.ToPath(uri)
+	srv, found, err := ss.StartForRequest(filename, method)
 	if err != nil {
 		return nil, fmt.Errorf("cound not start language server: %v", err)
 	}
+	if err := checkFound(uri, found); err != nil {
+		return nil, err
+	}
 	return srv, nil
 }
 
+func checkFound(uri protocol.DocumentURI, found bool) error {
+	if !found {
+		return fmt.Errorf("unknown language server for URI %q", uri)
+	}
+	return nil
+}
+
 func ListenAndServeProxy(ctx context.Context, cfg *config.Config, ss *ServerSet, fm *FileManager) error {
 	ln, err := p9service.Listen(ctx, cfg.ProxyNetwork, cfg.ProxyAddress)
 	if err != nil {
@@ -163,7 +268,7 @@ func ListenAndServeProxy(ctx context.Context, cfg *config.Config, ss *ServerSet,
 	// See https://github.com/golang/go/issues/28120#issuecomment-428978461
 	go func() {
 		<-ctx.Done()
-		ln.Close()
+		ln.Close() // also removes the unix socket
 	}()
 	for {
 		conn, err := ln.Accept()
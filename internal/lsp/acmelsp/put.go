@@ -0,0 +1,145 @@
+package acmelsp
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fhs/acme-lsp/internal/acmeutil"
+)
+
+// willSaveTimeout is how long to wait for the edits requested by
+// willSaveWaitUntil before the file is written without them.
+const willSaveTimeout = 2 * time.Second
+
+// watchPut intercepts Put executed in window winid, which is editing a
+// file handled by a LSP server, so that the file is prepared (see
+// beforePut) before acme writes it. It does nothing if the event file
+// of the window is already used by another program. Other events are
+// handled by acme as usual. It must be called with fm.mu held.
+func (fm *FileManager) watchPut(winid int) {
+	if fm.putWins[winid] {
+		return
+	}
+	w, err := acmeutil.OpenWin(winid)
+	if err != nil {
+		log.Printf("failed to open window %v: %v", winid, err)
+		return
+	}
+	if err := w.OpenEvent(); err != nil {
+		// Probably used by another program. The file will be
+		// formatted after Put.
+		w.CloseFiles()
+		if Verbose {
+			log.Printf("failed to open event file of window %v: %v", winid, err)
+		}
+		return
+	}
+	fm.putWins[winid] = true
+
+	go func() {
+		defer func() {
+			// Closing the files lets acme log the window deletion.
+			w.CloseFiles()
+			fm.mu.Lock()
+			delete(fm.putWins, winid)
+			delete(fm.prepared, winid)
+			fm.mu.Unlock()
+		}()
+		for ev := range w.EventChan() {
+			switch ev.C2 {
+			case 'x', 'X':
+				if strings.TrimSpace(string(ev.Text)) == "Put" {
+					fm.beforePut(w)
+				}
+				w.WriteEvent(ev)
+			case 'l', 'L':
+				w.WriteEvent(ev)
+			}
+		}
+	}()
+}
+
+// beforePut is called when Put is executed in window w. The servers
+// handling the file are told about the current content of the window
+// and that it's about to be saved, and the edits requested by them
+// are applied. The file is then formatted, instead of after it's
+// written.
+func (fm *FileManager) beforePut(w *acmeutil.Win) {
+	winid := w.ID()
+
+	// Forget about the previous Put, in case acme failed to write
+	// the file, until this one is prepared.
+	fm.mu.Lock()
+	delete(fm.prepared, winid)
+	fm.mu.Unlock()
+
+	name, err := w.Filename()
+	if err != nil {
+		log.Printf("failed to get filename of window %v: %v", w.ID(), err)
+		return
+	}
+	fm.mu.Lock()
+	_, ok := fm.wins[name]
+	fm.mu.Unlock()
+	if !ok {
+		return // Unknown language server.
+	}
+
+	if err := fm.didChange(winid, name); err != nil {
+		log.Printf("didChange failed in file manager: %v", err)
+		return
+	}
+	changed, err := fm.willSave(winid, name)
+	if err != nil {
+		log.Printf("willSave failed in file manager: %v", err)
+	}
+	if changed {
+		if err := fm.didChange(winid, name); err != nil {
+			log.Printf("didChange failed in file manager: %v", err)
+			return
+		}
+	}
+	if format, actions := fm.ss.putConfig(name); format {
+		if err := fm.format(winid, name, actions); err != nil && Verbose {
+			log.Printf("Format failed in file manager: %v", err)
+		}
+	}
+
+	// The file doesn't need to be formatted again after it's written.
+	fm.mu.Lock()
+	fm.prepared[winid] = true
+	fm.mu.Unlock()
+}
+
+// willSave tells the servers handling file name that it's about to be
+// saved. It returns true if the edits requested by any of them changed
+// the window.
+func (fm *FileManager) willSave(winid int, name string) (bool, error) {
+	srvs, err := fm.servers(name)
+	if err != nil {
+		return false, err
+	}
+
+	// Each server can take up to willSaveTimeout to respond, so don't
+	// hold up the other file events while waiting for them.
+	changed := false
+	err = forClients(winid, srvs, func(c *Client, w *acmeutil.Win) error {
+		ok, err := c.willSave(context.Background(), name, w)
+		changed = changed || ok
+		return err
+	})
+	return changed, err
+}
+
+// wasPrepared returns true if window winid was prepared by beforePut for
+// the Put that wrote its file, and forgets it.
+func (fm *FileManager) wasPrepared(winid int) bool {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	ok := fm.prepared[winid]
+	delete(fm.prepared, winid)
+	return ok
+}
@@ -0,0 +1,256 @@
+package acmelsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/fhs/acme-lsp/internal/lsp"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/fhs/acme-lsp/internal/lsp/text"
+)
+
+// registration is a capability registered dynamically by a server
+// using client/registerCapability.
+type registration struct {
+	id       string
+	selector protocol.DocumentSelector // nil if it applies to all documents
+	options  interface{}               // method specific registration options
+
+	// Compiled glob patterns of the document selector and file system
+	// watchers, keyed by pattern.
+	globs map[string]*regexp.Regexp
+}
+
+// registry contains the capabilities registered dynamically by a server,
+// keyed by method (e.g. "textDocument/formatting").
+type registry map[string][]registration
+
+// register adds the registration r, replacing any registration with
+// the same ID.
+func (reg registry) register(r *protocol.Registration) error {
+	var opt struct {
+		DocumentSelector protocol.DocumentSelector `json:"documentSelector"`
+	}
+	if err := decodeOptions(r.RegisterOptions, &opt); err != nil {
+		return fmt.Errorf("invalid registration options for %v: %v", r.Method, err)
+	}
+	globs := make(map[string]*regexp.Regexp)
+	for _, f := range opt.DocumentSelector {
+		if f.Pattern == "" {
+			continue
+		}
+		re, err := globRegexp(f.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid document selector for %v: %v", r.Method, err)
+		}
+		globs[f.Pattern] = re
+	}
+	if r.Method == "workspace/didChangeWatchedFiles" {
+		var opt protocol.DidChangeWatchedFilesRegistrationOptions
+		if err := decodeOptions(r.RegisterOptions, &opt); err != nil {
+			return fmt.Errorf("invalid registration options for %v: %v", r.Method, err)
+		}
+		for _, w := range opt.Watchers {
+			re, err := globRegexp(w.GlobPattern)
+			if err != nil {
+				return fmt.Errorf("invalid file system watcher: %v", err)
+			}
+			globs[w.GlobPattern] = re
+		}
+	}
+	reg.unregister(r.ID, r.Method)
+	reg[r.Method] = append(reg[r.Method], registration{
+		id:       r.ID,
+		selector: opt.DocumentSelector,
+		options:  r.RegisterOptions,
+		globs:    globs,
+	})
+	return nil
+}
+
+// unregister removes the registration with the given ID for method.
+func (reg registry) unregister(id string, method string) {
+	rs := reg[method]
+	for i := range rs {
+		if rs[i].id == id {
+			rs = append(rs[:i:i], rs[i+1:]...)
+			break
+		}
+	}
+	if len(rs) == 0 {
+		delete(reg, method)
+		return
+	}
+	reg[method] = rs
+}
+
+// matching returns the registrations for method that apply to document
+// uri with the given language ID. If uri is empty, only the registrations
+// that apply to all documents are returned.
+func (reg registry) matching(method string, uri protocol.DocumentURI, languageID string) []registration {
+	var rs []registration
+	for _, r := range reg[method] {
+		if r.selector == nil || (uri != "" && r.selectorMatches(uri, languageID)) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// selectorMatches returns true if any of the document filters in the
+// selector of r matches document uri with the given language ID.
+func (r *registration) selectorMatches(uri protocol.DocumentURI, languageID string) bool {
+	for _, f := range r.selector {
+		if f.Language != "" && f.Language != languageID {
+			continue
+		}
+		if f.Scheme != "" && f.Scheme != "file" {
+			continue
+		}
+		if f.Pattern != "" && !matchGlob(r.globs[f.Pattern], text.ToPath(uri)) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// capabilities returns a copy of the server capabilities cap with the
+// registrations that apply to document uri added to it.
+func (reg registry) capabilities(cap *protocol.ServerCapabilities, uri protocol.DocumentURI, languageID string) *protocol.ServerCapabilities {
+	c := *cap
+	for method := range reg {
+		for _, r := range reg.matching(method, uri, languageID) {
+			if err := addCapability(&c, method, r.options); err != nil {
+				log.Printf("failed to add capability %v registered by server: %v", method, err)
+			}
+		}
+	}
+	return &c
+}
+
+// addCapability adds the capability for method, registered with the
+// given options, to cap. Registrations of methods not used by acme-lsp
+// are ignored.
+func addCapability(cap *protocol.ServerCapabilities, method string, options interface{}) error {
+	switch method {
+	case "textDocument/completion":
+		var opt protocol.CompletionOptions
+		if err := decodeOptions(options, &opt); err != nil {
+			return err
+		}
+		cap.CompletionProvider = &opt
+	case "textDocument/hover":
+		cap.HoverProvider = true
+	case "textDocument/signatureHelp":
+		var opt protocol.SignatureHelpOptions
+		if err := decodeOptions(options, &opt); err != nil {
+			return err
+		}
+		cap.SignatureHelpProvider = &opt
+	case "textDocument/declaration":
+		cap.DeclarationProvider = true
+	case "textDocument/definition":
+		cap.DefinitionProvider = true
+	case "textDocument/typeDefinition":
+		cap.TypeDefinitionProvider = true
+	case "textDocument/implementation":
+		cap.ImplementationProvider = true
+	case "textDocument/references":
+		cap.ReferencesProvider = true
+	case "textDocument/documentHighlight":
+		cap.DocumentHighlightProvider = true
+	case "textDocument/documentSymbol":
+		cap.DocumentSymbolProvider = true
+	case "textDocument/formatting":
+		cap.DocumentFormattingProvider = true
+	case "textDocument/rangeFormatting":
+		cap.DocumentRangeFormattingProvider = true
+	case "textDocument/codeAction":
+		var opt protocol.CodeActionOptions
+		if err := decodeOptions(options, &opt); err != nil {
+			return err
+		}
+		cap.CodeActionProvider = mergeCodeActionProvider(cap.CodeActionProvider, opt.CodeActionKinds)
+	case "textDocument/rename":
+		if options == nil {
+			cap.RenameProvider = true
+		} else {
+			cap.RenameProvider = options
+		}
+	case "workspace/executeCommand":
+		var opt protocol.ExecuteCommandOptions
+		if err := decodeOptions(options, &opt); err != nil {
+			return err
+		}
+		if cap.ExecuteCommandProvider != nil {
+			opt.Commands = append(append([]string(nil), cap.ExecuteCommandProvider.Commands...), opt.Commands...)
+		}
+		cap.ExecuteCommandProvider = &opt
+	case "textDocument/willSave", "textDocument/willSaveWaitUntil", "textDocument/didSave":
+		sync := lsp.ServerTextDocumentSyncOptions(cap)
+		switch method {
+		case "textDocument/willSave":
+			sync.WillSave = true
+		case "textDocument/willSaveWaitUntil":
+			sync.WillSaveWaitUntil = true
+		case "textDocument/didSave":
+			var opt protocol.SaveOptions
+			if err := decodeOptions(options, &opt); err != nil {
+				return err
+			}
+			sync.Save = &opt
+		}
+		cap.TextDocumentSync = sync
+	case "textDocument/diagnostic":
+		if _, err := protocol.ToDiagnosticOptions(options); err != nil {
+			return err
+		}
+		cap.DiagnosticProvider = options
+	}
+	return nil
+}
+
+// mergeCodeActionProvider returns the code action provider capability
+// that supports the code actions supported by v, which is either a
+// boolean or CodeActionOptions, and the given kinds. Empty kinds means
+// all kinds of code actions are supported.
+func mergeCodeActionProvider(v interface{}, kinds []protocol.CodeActionKind) interface{} {
+	if len(kinds) == 0 {
+		return true
+	}
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return true
+		}
+	case map[string]interface{}:
+		opt, err := protocol.ToCodeActionOptions(v)
+		if err != nil {
+			log.Printf("failed to decode CodeActionOptions: %v", err)
+			break
+		}
+		if len(opt.CodeActionKinds) == 0 {
+			return true
+		}
+		kinds = append(append([]protocol.CodeActionKind(nil), opt.CodeActionKinds...), kinds...)
+	}
+	return map[string]interface{}{
+		"codeActionKinds": kinds,
+	}
+}
+
+// decodeOptions decodes registration options v, usually unmarshaled
+// from JSON into a map, into opt. Nil options are left as is.
+func decodeOptions(v interface{}, opt interface{}) error {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, opt)
+}
@@ -0,0 +1,125 @@
+package acmelsp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/fhs/acme-lsp/internal/lsp"
+	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/fhs/acme-lsp/internal/lsp/text"
+	"github.com/google/go-cmp/cmp"
+)
+
+// registrations decodes the registrations in JSON format, as sent
+// by the server.
+func registrations(t *testing.T, s string) []protocol.Registration {
+	var params protocol.RegistrationParams
+	if err := json.Unmarshal([]byte(s), &params); err != nil {
+		t.Fatalf("failed to decode registrations: %v", err)
+	}
+	return params.Registrations
+}
+
+func TestClientRegisterCapability(t *testing.T) {
+	c := &Client{
+		cfg: &ClientConfig{
+			FilenameHandler: &config.FilenameHandler{LanguageID: "go"},
+		},
+		initializeResult: &protocol.InitializeResult{
+			Capabilities: protocol.ServerCapabilities{
+				CodeActionProvider: map[string]interface{}{
+					"codeActionKinds": []interface{}{"quickfix"},
+				},
+			},
+		},
+	}
+	err := c.register(registrations(t, `{"registrations": [
+		{"id": "1", "method": "textDocument/formatting",
+			"registerOptions": {"documentSelector": [{"language": "go"}]}},
+		{"id": "2", "method": "textDocument/codeAction",
+			"registerOptions": {"documentSelector": [{"pattern": "**/*.go"}],
+				"codeActionKinds": ["source.organizeImports"]}},
+		{"id": "3", "method": "textDocument/willSaveWaitUntil",
+			"registerOptions": {"documentSelector": null}},
+		{"id": "4", "method": "workspace/executeCommand",
+			"registerOptions": {"commands": ["gopls.tidy"]}},
+		{"id": "5", "method": "textDocument/hover",
+			"registerOptions": {"documentSelector": [{"language": "python"}]}}
+	]}`))
+	if err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if rs := c.registrations["textDocument/codeAction"]; len(rs) != 1 || rs[0].globs["**/*.go"] == nil {
+		t.Errorf("document selector pattern isn't compiled when it's registered")
+	}
+
+	goURI := text.ToURI("/home/gopher/hello/main.go")
+	cap := c.capabilities(goURI)
+	for _, method := range []string{
+		"textDocument/formatting",
+		"textDocument/codeAction",
+		"textDocument/willSaveWaitUntil",
+		"workspace/executeCommand",
+	} {
+		if !lsp.ServerProvides(cap, method) {
+			t.Errorf("server doesn't provide %v after it's registered", method)
+		}
+	}
+	if lsp.ServerProvides(cap, "textDocument/hover") {
+		t.Errorf("server provides hover registered for another language")
+	}
+	if !lsp.ServerProvidesCommand(cap, "gopls.tidy") {
+		t.Errorf("server doesn't provide command registered")
+	}
+	kinds := []protocol.CodeActionKind{protocol.SourceOrganizeImports, protocol.QuickFix}
+	if got := lsp.CompatibleCodeActions(cap, kinds); !cmp.Equal(got, kinds) {
+		t.Errorf("compatible code actions are %v; want %v", got, kinds)
+	}
+
+	// Registrations restricted to some documents don't apply to others.
+	cap = c.capabilities(text.ToURI("/home/gopher/hello/README"))
+	if got, want := lsp.CompatibleCodeActions(cap, kinds), kinds[1:]; !cmp.Equal(got, want) {
+		t.Errorf("compatible code actions for README are %v; want %v", got, want)
+	}
+	if !lsp.ServerProvides(cap, "textDocument/willSaveWaitUntil") {
+		t.Errorf("server doesn't provide willSaveWaitUntil registered for all documents")
+	}
+
+	// The static capabilities are unchanged.
+	r, err := c.InitializeResult(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("InitializeResult failed: %v", err)
+	}
+	if lsp.ServerProvides(&r.Capabilities, "textDocument/formatting") {
+		t.Errorf("static capabilities changed by registration")
+	}
+	r, err = c.InitializeResult(context.Background(), &protocol.TextDocumentIdentifier{URI: goURI})
+	if err != nil {
+		t.Fatalf("InitializeResult failed: %v", err)
+	}
+	if !lsp.ServerProvides(&r.Capabilities, "textDocument/formatting") {
+		t.Errorf("InitializeResult doesn't include registered capabilities")
+	}
+
+	c.unregister([]protocol.Unregistration{
+		{ID: "1", Method: "textDocument/formatting"},
+		{ID: "2", Method: "textDocument/codeAction"},
+	})
+	cap = c.capabilities(goURI)
+	if lsp.ServerProvides(cap, "textDocument/formatting") {
+		t.Errorf("server provides formatting after it's unregistered")
+	}
+	if got, want := lsp.CompatibleCodeActions(cap, kinds), kinds[1:]; !cmp.Equal(got, want) {
+		t.Errorf("compatible code actions after unregistration are %v; want %v", got, want)
+	}
+
+	err = c.register(registrations(t, `{"registrations": [
+		{"id": "6", "method": "textDocument/formatting",
+			"registerOptions": {"documentSelector": [{"pattern": "{*.go"}]}}
+	]}`))
+	if err == nil {
+		t.Errorf("registration with invalid pattern succeeded")
+	}
+}
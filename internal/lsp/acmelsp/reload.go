@@ -0,0 +1,150 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fhs/acme-lsp/internal/acme"
+	"github.com/fhs/acme-lsp/internal/lsp/acmelsp/config"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/fhs/acme-lsp/internal/lsp/text"
+)
+
+// configWatchInterval is how often the configuration file is checked
+// for changes when ReloadOnChange is set.
+const configWatchInterval = 2 * time.Second
+
+// config returns the current configuration.
+func (fm *FileManager) config() *config.Config {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.cfg
+}
+
+// Reload loads the configuration file again and applies it. Servers
+// whose configuration changed are restarted, the others are notified of
+// changes to their Settings, and the files open in acme are opened in
+// the new servers. The new FormatOnPut and CodeActionsOnPut apply to the
+// next Put. If some of the files can't be opened, the others are still
+// opened and the errors are combined.
+//
+// The workspace directories, and the settings of acme-lsp itself (e.g.
+// the proxy and acme addresses), aren't reloaded.
+func (fm *FileManager) Reload(ctx context.Context) error {
+	cfg, err := fm.config().Reload()
+	if err != nil {
+		return fmt.Errorf("failed to reload configuration: %v", err)
+	}
+	added, err := fm.ss.reload(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to reload configuration: %v", err)
+	}
+
+	fm.mu.Lock()
+	fm.cfg = cfg
+	for name := range fm.wins {
+		if fm.ss.MatchFile(name) == nil {
+			delete(fm.wins, name)
+			fm.ss.diagWriter.DropDiagnostics(text.ToURI(name))
+		}
+	}
+	fm.mu.Unlock()
+
+	// Open the files in the servers that are new or were shut down.
+	type instance struct {
+		info *ServerInfo
+		root string
+	}
+	stopped := make(map[instance]bool)
+	fm.mu.Lock()
+	for name := range fm.wins {
+		for _, info := range fm.ss.MatchFiles(name) {
+			stopped[instance{info, info.root(name)}] = true
+		}
+	}
+	fm.mu.Unlock()
+	var errs errorList
+	for inst := range stopped {
+		running := false
+		for _, srv := range inst.info.running() {
+			running = running || srv.root == inst.root
+		}
+		if running {
+			continue
+		}
+		if err := fm.reopen(inst.info, inst.root); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Open the files which are now handled by a server.
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	for _, wi := range wins {
+		fm.mu.Lock()
+		_, ok := fm.wins[wi.Name]
+		fm.mu.Unlock()
+		if ok {
+			continue
+		}
+		if err := fm.didOpen(wi.ID, wi.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	msg := fmt.Sprintf("configuration reloaded; %v servers added or restarted", len(added))
+	if fm.ss.msgWriter != nil {
+		fm.ss.msgWriter.WriteMessage("acme-lsp", protocol.Info, msg)
+	} else if Verbose {
+		log.Print(msg)
+	}
+	return errs.err()
+}
+
+// WatchConfig reloads the configuration file when it changes, if
+// ReloadOnChange is set in the current configuration, until ctx is done.
+func (fm *FileManager) WatchConfig(ctx context.Context) {
+	filename, err := config.Filename()
+	if err != nil {
+		log.Printf("could not find configuration file: %v", err)
+		return
+	}
+	modTime := func() time.Time {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	last := modTime()
+
+	t := time.NewTicker(configWatchInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		mt := modTime()
+		if mt.Equal(last) {
+			continue
+		}
+		last = mt
+		if !fm.config().ReloadOnChange {
+			continue
+		}
+		if err := fm.Reload(ctx); err != nil {
+			if fm.ss.msgWriter != nil {
+				fm.ss.msgWriter.WriteMessage("acme-lsp", protocol.Error, err.Error())
+			} else {
+				log.Print(err)
+			}
+		}
+	}
+}
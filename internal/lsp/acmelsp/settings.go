@@ -0,0 +1,83 @@
+package acmelsp
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/fhs/acme-lsp/internal/lsp/text"
+)
+
+// scopedSettings returns settings overridden by the settings in scoped
+// of the directories containing scope, which is the URI of a file or
+// directory. The settings of outer directories are applied first.
+func scopedSettings(settings map[string]interface{}, scoped map[string]map[string]interface{}, scope protocol.DocumentURI) map[string]interface{} {
+	if scope == "" || len(scoped) == 0 {
+		return settings
+	}
+	name := filepath.Clean(text.ToPath(scope))
+	var dirs []string
+	for dir := range scoped {
+		d := filepath.Clean(dir)
+		if name == d || strings.HasPrefix(name, d+string(filepath.Separator)) || d == string(filepath.Separator) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(filepath.Clean(dirs[i])) < len(filepath.Clean(dirs[j]))
+	})
+	for _, dir := range dirs {
+		settings = mergeSettings(settings, scoped[dir])
+	}
+	return settings
+}
+
+// mergeSettings returns a copy of dst with src merged into it. Tables
+// present in both are merged recursively, and other values in src
+// replace the ones in dst.
+func mergeSettings(dst, src map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		m[k] = v
+	}
+	for k, v := range src {
+		if vm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := m[k].(map[string]interface{}); ok {
+				m[k] = mergeSettings(dm, vm)
+				continue
+			}
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// settingsSection returns the value of section (e.g. "python.analysis")
+// within settings, or nil if it's not found. The section is a dot
+// separated path of nested tables, but the table names may also contain
+// dots (e.g. "python.analysis" may be a single table). An empty section
+// refers to all the settings.
+func settingsSection(settings interface{}, section string) interface{} {
+	if section == "" {
+		return settings
+	}
+	m, ok := settings.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if v, ok := m[section]; ok {
+		return v
+	}
+	for i, c := range section {
+		if c != '.' {
+			continue
+		}
+		if v, ok := m[section[:i]]; ok {
+			if v := settingsSection(v, section[i+1:]); v != nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
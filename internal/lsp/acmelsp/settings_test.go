@@ -0,0 +1,95 @@
+package acmelsp
+
+import (
+	"testing"
+
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSettingsSection(t *testing.T) {
+	settings := map[string]interface{}{
+		"python": map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"typeCheckingMode": "basic",
+			},
+			"pythonPath": "/usr/bin/python3",
+		},
+		"yaml.format": map[string]interface{}{
+			"enable": true,
+		},
+	}
+	for _, tc := range []struct {
+		section string
+		want    interface{}
+	}{
+		{"", settings},
+		{"python.pythonPath", "/usr/bin/python3"},
+		{"python.analysis", map[string]interface{}{"typeCheckingMode": "basic"}},
+		{"python.analysis.typeCheckingMode", "basic"},
+		{"yaml.format.enable", true},
+		{"python.missing", nil},
+		{"rust-analyzer", nil},
+	} {
+		got := settingsSection(settings, tc.section)
+		if !cmp.Equal(got, tc.want) {
+			t.Errorf("section %q is %v; want %v", tc.section, got, tc.want)
+		}
+	}
+}
+
+func TestScopedSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"python": map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"typeCheckingMode":      "basic",
+				"autoImportCompletions": true,
+			},
+		},
+	}
+	scoped := map[string]map[string]interface{}{
+		"/src/legacy": {
+			"python": map[string]interface{}{
+				"analysis": map[string]interface{}{
+					"typeCheckingMode": "off",
+				},
+			},
+		},
+		"/src/legacy/new/": {
+			"python": map[string]interface{}{
+				"analysis": map[string]interface{}{
+					"typeCheckingMode": "strict",
+				},
+			},
+		},
+	}
+	mode := func(typ string) map[string]interface{} {
+		return map[string]interface{}{
+			"python": map[string]interface{}{
+				"analysis": map[string]interface{}{
+					"typeCheckingMode":      typ,
+					"autoImportCompletions": true,
+				},
+			},
+		}
+	}
+	for _, tc := range []struct {
+		scope protocol.DocumentURI
+		want  map[string]interface{}
+	}{
+		{"", settings},
+		{"file:///src/app/main.py", settings},
+		{"file:///src/legacy-app/main.py", settings},
+		{"file:///src/legacy", mode("off")},
+		{"file:///src/legacy/main.py", mode("off")},
+		{"file:///src/legacy/new/main.py", mode("strict")},
+	} {
+		got := scopedSettings(settings, scoped, tc.scope)
+		if !cmp.Equal(got, tc.want) {
+			t.Errorf("settings for scope %q are %v; want %v", tc.scope, got, tc.want)
+		}
+	}
+	if got := settingsSection(settings, "python.analysis.typeCheckingMode"); got != "basic" {
+		t.Errorf("settings were modified by merge")
+	}
+}
@@ -0,0 +1,256 @@
+package acmelsp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fhs/acme-lsp/internal/acme"
+	"github.com/fhs/acme-lsp/internal/acmeutil"
+	"github.com/fhs/acme-lsp/internal/fswatch"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/fhs/acme-lsp/internal/lsp/text"
+)
+
+// watchPollInterval is how often the workspace folders are checked for
+// changes if they can't be watched using inotify.
+const watchPollInterval = 2 * time.Second
+
+// watchFiles watches the workspace folders of the servers that registered
+// file system watchers. The servers are told about the files created,
+// changed or deleted outside acme, and the windows of the changed files
+// are reloaded if they're clean.
+func (fm *FileManager) watchFiles() {
+	w := fswatch.New(watchPollInterval)
+	defer w.Close()
+
+	for {
+		select {
+		case <-fm.watchUpdate:
+			if err := w.SetRoots(fm.ss.watchedDirs()); err != nil {
+				log.Printf("failed to watch workspace folders: %v", err)
+			}
+		case events, ok := <-w.Events():
+			if !ok {
+				return
+			}
+			fm.ss.didChangeWatchedFiles(events)
+			fm.reloadChanged(events)
+		}
+	}
+}
+
+// updateWatches tells watchFiles to watch the current workspace folders
+// of the servers that registered file system watchers.
+func (fm *FileManager) updateWatches() {
+	select {
+	case fm.watchUpdate <- struct{}{}:
+	default:
+		// Update already pending.
+	}
+}
+
+// reloadChanged reloads the windows of the files changed on disk, if the
+// windows are clean. Windows with unsaved changes are left alone.
+func (fm *FileManager) reloadChanged(events []fswatch.Event) {
+	changed := make(map[string]bool)
+	fm.mu.Lock()
+	for _, ev := range events {
+		if _, ok := fm.wins[ev.Name]; ok && ev.Op != fswatch.Delete {
+			changed[ev.Name] = true
+		}
+	}
+	fm.mu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+
+	wins, err := acme.Windows()
+	if err != nil {
+		log.Printf("failed to read list of acme windows: %v", err)
+		return
+	}
+	for _, info := range wins {
+		if changed[info.Name] {
+			if err := reloadWin(info.ID, info.Name); err != nil {
+				log.Printf("failed to reload window %v: %v", info.ID, err)
+			}
+		}
+	}
+}
+
+// reloadWin executes Get in window winid, which is editing file name, if
+// it's clean and the file is different from the window.
+func reloadWin(winid int, name string) error {
+	w, err := acmeutil.OpenWin(winid)
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+
+	dirty, err := w.IsDirty()
+	if err != nil || dirty {
+		return err
+	}
+	disk, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil // deleted or replaced since the change
+	}
+	body, err := readFile(w)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(body, disk) {
+		return nil // e.g. written by Put
+	}
+	if Verbose {
+		log.Printf("reloading %v changed on disk", name)
+	}
+	// Acme logs the get event, and the servers are told about the new
+	// content by the file manager.
+	return w.Ctl("get")
+}
+
+// watchedDirs returns the directories watched for the running servers
+// that registered file system watchers.
+func (ss *ServerSet) watchedDirs() []string {
+	var dirs []string
+	for _, info := range ss.infos() {
+		for _, srv := range info.running() {
+			dirs = append(dirs, srv.Client.watchedDirs()...)
+		}
+	}
+	return dirs
+}
+
+// didChangeWatchedFiles tells the running servers about the files changed
+// on disk that they're watching.
+func (ss *ServerSet) didChangeWatchedFiles(events []fswatch.Event) {
+	for _, info := range ss.infos() {
+		for _, srv := range info.running() {
+			if err := srv.Client.didChangeWatchedFiles(context.Background(), events); err != nil {
+				log.Printf("didChangeWatchedFiles failed for %v: %v", srv.Client.serverName(), err)
+			}
+		}
+	}
+}
+
+// watchersChanged is called when the file system watchers registered by
+// a server or its workspace folders change.
+func (ss *ServerSet) watchersChanged() {
+	if ss.fm != nil {
+		ss.fm.updateWatches()
+	}
+}
+
+// fileWatcher is a file system watcher registered by a server, with its
+// glob pattern compiled when it was registered.
+type fileWatcher struct {
+	protocol.FileSystemWatcher
+	re *regexp.Regexp
+}
+
+// fileWatchers returns the file system watchers registered by the server.
+func (c *Client) fileWatchers() []fileWatcher {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var watchers []fileWatcher
+	for _, r := range c.registrations["workspace/didChangeWatchedFiles"] {
+		var opt protocol.DidChangeWatchedFilesRegistrationOptions
+		if err := decodeOptions(r.options, &opt); err != nil {
+			continue // checked when it was registered
+		}
+		for _, w := range opt.Watchers {
+			watchers = append(watchers, fileWatcher{w, r.globs[w.GlobPattern]})
+		}
+	}
+	return watchers
+}
+
+// watchedDirs returns the directories where the server watches files,
+// which are its workspace folders or root directory. It returns nil if
+// the server hasn't registered any file system watchers.
+func (c *Client) watchedDirs() []string {
+	if len(c.fileWatchers()) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	folders := c.workspaces
+	c.mu.Unlock()
+
+	var dirs []string
+	for _, f := range folders {
+		dirs = append(dirs, text.ToPath(f.URI))
+	}
+	if len(dirs) == 0 && c.cfg != nil && c.cfg.RootDirectory != "" {
+		// Don't watch the whole file system.
+		d, err := filepath.Abs(c.cfg.RootDirectory)
+		if err == nil && filepath.Dir(d) != d {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+// didChangeWatchedFiles tells the server about the changes to the files
+// that match its file system watchers in the directories it's watching.
+func (c *Client) didChangeWatchedFiles(ctx context.Context, events []fswatch.Event) error {
+	watchers := c.fileWatchers()
+	if len(watchers) == 0 {
+		return nil
+	}
+	dirs := c.watchedDirs()
+
+	var changes []protocol.FileEvent
+	for _, ev := range events {
+		if !inDirs(dirs, ev.Name) || !watched(watchers, ev) {
+			continue
+		}
+		changes = append(changes, protocol.FileEvent{
+			URI:  text.ToURI(ev.Name),
+			Type: protocol.FileChangeType(ev.Op),
+		})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return c.DidChangeWatchedFiles(ctx, &protocol.DidChangeWatchedFilesParams{
+		Changes: changes,
+	})
+}
+
+// inDirs returns true if file name is inside one of dirs.
+func inDirs(dirs []string, name string) bool {
+	for _, d := range dirs {
+		if strings.HasPrefix(name, strings.TrimSuffix(d, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// watched returns true if the change ev matches any of watchers.
+func watched(watchers []fileWatcher, ev fswatch.Event) bool {
+	var kind protocol.WatchKind
+	switch ev.Op {
+	case fswatch.Create:
+		kind = protocol.WatchCreate
+	case fswatch.Change:
+		kind = protocol.WatchChange
+	case fswatch.Delete:
+		kind = protocol.WatchDelete
+	}
+	for _, w := range watchers {
+		// WatchKind is a bit mask, which defaults to all kinds.
+		if (w.Kind == 0 || int(w.Kind)&int(kind) != 0) && matchGlob(w.re, ev.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package acmelsp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fhs/acme-lsp/internal/fswatch"
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+	"github.com/fhs/acme-lsp/internal/lsp/text"
+	"github.com/google/go-cmp/cmp"
+)
+
+type watchServer struct {
+	protocol.Server
+	changes []protocol.FileEvent
+}
+
+func (s *watchServer) DidChangeWatchedFiles(ctx context.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	s.changes = append(s.changes, params.Changes...)
+	return nil
+}
+
+func TestClientDidChangeWatchedFiles(t *testing.T) {
+	srv := &watchServer{}
+	changed := 0
+	c := &Client{
+		Server: srv,
+		cfg: &ClientConfig{
+			WatchersChanged: func() { changed++ },
+		},
+		initializeResult: &protocol.InitializeResult{},
+		workspaces: []protocol.WorkspaceFolder{
+			{URI: text.ToURI("/home/gopher/hello"), Name: "hello"},
+		},
+	}
+	events := []fswatch.Event{
+		{Name: "/home/gopher/hello/main.go", Op: fswatch.Change},
+		{Name: "/home/gopher/hello/go.mod", Op: fswatch.Create},
+		{Name: "/home/gopher/hello/gen.go", Op: fswatch.Delete},
+		{Name: "/home/gopher/hello/README", Op: fswatch.Change},
+		{Name: "/home/gopher/other/main.go", Op: fswatch.Change},
+	}
+
+	// Nothing is sent until the server registers watchers.
+	if got := c.watchedDirs(); got != nil {
+		t.Errorf("watched directories are %v before registration", got)
+	}
+	if err := c.didChangeWatchedFiles(context.Background(), events); err != nil {
+		t.Fatalf("didChangeWatchedFiles failed: %v", err)
+	}
+	if len(srv.changes) > 0 {
+		t.Errorf("changes sent before registration: %v", srv.changes)
+	}
+
+	err := c.register(registrations(t, `{"registrations": [
+		{"id": "1", "method": "workspace/didChangeWatchedFiles",
+			"registerOptions": {"watchers": [
+				{"globPattern": "**/*.go", "kind": 5},
+				{"globPattern": "**/go.{mod,sum}"}
+			]}}
+	]}`))
+	if err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("WatchersChanged called %v times; want 1", changed)
+	}
+	if got, want := c.watchedDirs(), []string{"/home/gopher/hello"}; !cmp.Equal(got, want) {
+		t.Errorf("watched directories are %v; want %v", got, want)
+	}
+	if err := c.didChangeWatchedFiles(context.Background(), events); err != nil {
+		t.Fatalf("didChangeWatchedFiles failed: %v", err)
+	}
+	want := []protocol.FileEvent{
+		{URI: text.ToURI("/home/gopher/hello/go.mod"), Type: protocol.Created},
+		{URI: text.ToURI("/home/gopher/hello/gen.go"), Type: protocol.Deleted},
+	}
+	if !cmp.Equal(srv.changes, want) {
+		t.Errorf("changes sent are %v; want %v", srv.changes, want)
+	}
+
+	c.unregister([]protocol.Unregistration{
+		{ID: "1", Method: "workspace/didChangeWatchedFiles"},
+	})
+	if changed != 2 {
+		t.Errorf("WatchersChanged called %v times; want 2", changed)
+	}
+	if got := c.watchedDirs(); got != nil {
+		t.Errorf("watched directories are %v after unregistration", got)
+	}
+
+	err = c.register(registrations(t, `{"registrations": [
+		{"id": "2", "method": "workspace/didChangeWatchedFiles",
+			"registerOptions": {"watchers": [{"globPattern": "**/*.{go"}]}}
+	]}`))
+	if err == nil {
+		t.Errorf("registration with invalid glob pattern succeeded")
+	}
+}
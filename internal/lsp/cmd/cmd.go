@@ -3,6 +3,7 @@ package cmd
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 
@@ -12,16 +13,26 @@ import (
 )
 
 func Setup(flags config.Flags) *config.Config {
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("failed to load config file: %v", err)
-	}
-	err = cfg.ParseFlags(flags, flag.CommandLine, os.Args[1:])
-	if err != nil {
-		// Unreached since flag.CommandLine uses flag.ExitOnError.
-		log.Fatalf("failed to parse flags: %v", err)
+	cfg, loadErr := config.Load()
+	if loadErr != nil {
+		// Parse the flags anyway, so that the error is reported
+		// along with the other problems if the configuration is
+		// being checked.
+		cfg = config.Default()
 	}
+	// Only an invalid -preset can fail since flag.CommandLine uses
+	// flag.ExitOnError.
+	flagErr := cfg.ParseFlags(flags, flag.CommandLine, os.Args[1:])
 
+	if cfg.CheckConfig {
+		os.Exit(checkConfig(cfg, loadErr, flagErr))
+	}
+	if loadErr != nil {
+		log.Fatalf("failed to load config file: %v", loadErr)
+	}
+	if flagErr != nil {
+		log.Fatalf("failed to parse flags: %v", flagErr)
+	}
 	if cfg.ShowConfig {
 		config.Write(os.Stdout, cfg)
 		os.Exit(0)
@@ -36,3 +47,39 @@ func Setup(flags config.Flags) *config.Config {
 	}
 	return cfg
 }
+
+// checkConfig writes the problems found in the configuration files to
+// stderr, including the ones that prevented loading them (loadErr) and
+// parsing the flags (flagErr), and returns the exit status.
+func checkConfig(cfg *config.Config, loadErr, flagErr error) int {
+	var problems []config.Problem
+	if loadErr != nil {
+		p, ok := loadErr.(*config.Problem)
+		if !ok {
+			// The file couldn't be read or parsed.
+			log.Printf("failed to load config file: %v", loadErr)
+			return 1
+		}
+		problems = append(problems, *p)
+	} else {
+		var err error
+		problems, err = config.Check(cfg)
+		if err != nil {
+			log.Printf("failed to check config file: %v", err)
+			return 1
+		}
+	}
+	if flagErr != nil {
+		problems = append(problems, config.Problem{
+			Filename: "command line",
+			Message:  flagErr.Error(),
+		})
+	}
+	for i := range problems {
+		fmt.Fprintf(os.Stderr, "%v\n", &problems[i])
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
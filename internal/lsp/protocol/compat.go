@@ -68,6 +68,29 @@ func ToCodeActionOptions(v map[string]interface{}) (*CodeActionOptions, error) {
 	return &opt, nil
 }
 
+// ToTextDocumentSyncOptions converts ServerCapabilities.TextDocumentSync,
+// which is either TextDocumentSyncOptions or TextDocumentSyncKind, to
+// TextDocumentSyncOptions.
+func ToTextDocumentSyncOptions(v interface{}) (*TextDocumentSyncOptions, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 0 && b[0] != '{' {
+		var kind TextDocumentSyncKind
+		if err := json.Unmarshal(b, &kind); err != nil {
+			return nil, err
+		}
+		return &TextDocumentSyncOptions{OpenClose: true, Change: kind}, nil
+	}
+	var opt TextDocumentSyncOptions
+	err = json.Unmarshal(b, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
 // Locations is a type which represents the union of Location and []Location
 type Locations []Location
 
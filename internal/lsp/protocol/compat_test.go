@@ -279,3 +279,131 @@ func TestToCodeActionOptions(t *testing.T) {
 		t.Errorf("got %#v; want %#v", got, want)
 	}
 }
+
+func TestToDiagnosticOptions(t *testing.T) {
+	data := []byte(`{"capabilities": {"diagnosticProvider": {"identifier": "rust-analyzer", "interFileDependencies": true, "workspaceDiagnostics": false}}}`)
+	var res InitializeResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatalf("unmarshal of %q failed: %v", data, err)
+	}
+	want := &DiagnosticOptions{
+		Identifier:            "rust-analyzer",
+		InterFileDependencies: true,
+	}
+	got, err := ToDiagnosticOptions(res.Capabilities.DiagnosticProvider)
+	if err != nil {
+		t.Fatalf("marshal or unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestDocumentDiagnosticReport(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want DocumentDiagnosticReport
+	}{
+		{
+			data: []byte(`{"kind":"unchanged","resultId":"42"}`),
+			want: DocumentDiagnosticReport{
+				Kind:     DiagnosticUnchanged,
+				ResultID: "42",
+			},
+		},
+		{
+			data: []byte(`{"kind":"full","resultId":"43","items":[{"range":{"start":{"line":1,"character":2},"end":{"line":1,"character":5}},"severity":1,"message":"undefined: x"}],"relatedDocuments":{"file:///a.go":{"kind":"unchanged","resultId":"7"}}}`),
+			want: DocumentDiagnosticReport{
+				Kind:     DiagnosticFull,
+				ResultID: "43",
+				Items: []Diagnostic{
+					{
+						Range: Range{
+							Start: Position{Line: 1, Character: 2},
+							End:   Position{Line: 1, Character: 5},
+						},
+						Severity: SeverityError,
+						Message:  "undefined: x",
+					},
+				},
+				RelatedDocuments: map[DocumentURI]DocumentDiagnosticReport{
+					"file:///a.go": {
+						Kind:     DiagnosticUnchanged,
+						ResultID: "7",
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		var got DocumentDiagnosticReport
+		if err := json.Unmarshal(test.data, &got); err != nil {
+			t.Errorf("json.Unmarshal %q error: %s", test.data, err)
+			continue
+		}
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("Unmarshaled %q, expected %#v, but got %#v", string(test.data), test.want, got)
+		}
+	}
+}
+
+func TestToWorkDoneProgress(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		want *WorkDoneProgress
+	}{
+		{
+			`{"token": 1, "value": {"kind": "begin", "title": "Loading packages", "percentage": 40}}`,
+			&WorkDoneProgress{Kind: ProgressBegin, Title: "Loading packages", Percentage: 40},
+		},
+		{
+			`{"token": "x", "value": {"kind": "end", "message": "Finished"}}`,
+			&WorkDoneProgress{Kind: ProgressEnd, Message: "Finished"},
+		},
+		{
+			`{"token": "x", "value": [{"uri": "file:///a.go"}]}`,
+			&WorkDoneProgress{},
+		},
+	} {
+		var params ProgressParams
+		if err := json.Unmarshal([]byte(tc.data), &params); err != nil {
+			t.Fatalf("unmarshal of %q failed: %v", tc.data, err)
+		}
+		got, err := ToWorkDoneProgress(params.Value)
+		if err != nil {
+			t.Fatalf("marshal or unmarshal failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got %#v; want %#v", got, tc.want)
+		}
+	}
+}
+
+func TestToTextDocumentSyncOptions(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want *TextDocumentSyncOptions
+	}{
+		{2.0, &TextDocumentSyncOptions{OpenClose: true, Change: Incremental}},
+		{
+			map[string]interface{}{
+				"change":   1.0,
+				"willSave": true,
+				"save":     map[string]interface{}{"includeText": true},
+			},
+			&TextDocumentSyncOptions{
+				Change:   Full,
+				WillSave: true,
+				Save:     &SaveOptions{IncludeText: true},
+			},
+		},
+	} {
+		got, err := ToTextDocumentSyncOptions(tc.v)
+		if err != nil {
+			t.Fatalf("ToTextDocumentSyncOptions failed: %v", err)
+		}
+		if !cmp.Equal(got, tc.want) {
+			t.Errorf("ToTextDocumentSyncOptions(%v) = %+v; want %+v", tc.v, got, tc.want)
+		}
+	}
+}
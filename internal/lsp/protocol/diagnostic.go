@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/jsonrpc2"
+	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/telemetry/log"
+)
+
+// Pull model diagnostics were added in LSP 3.17, which is newer than the
+// version tsprotocol.go and tsserver.go were generated from. The types and
+// methods here are written by hand following the specification:
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#textDocument_diagnostic
+
+// DiagnosticClientCapabilities are the client capabilities specific
+// to diagnostic pull requests.
+type DiagnosticClientCapabilities struct {
+	// Whether implementation supports dynamic registration.
+	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+
+	// Whether the clients supports related documents for document
+	// diagnostic pulls.
+	RelatedDocumentSupport bool `json:"relatedDocumentSupport,omitempty"`
+}
+
+// DiagnosticWorkspaceClientCapabilities are the workspace client
+// capabilities specific to diagnostic pull requests.
+type DiagnosticWorkspaceClientCapabilities struct {
+	// Whether the client implementation supports a refresh request sent
+	// from the server to the client.
+	RefreshSupport bool `json:"refreshSupport,omitempty"`
+}
+
+// DiagnosticOptions are the server options for pull model diagnostics.
+type DiagnosticOptions struct {
+	// An optional identifier under which the diagnostics are managed by
+	// the client.
+	Identifier string `json:"identifier,omitempty"`
+
+	// Whether the language has inter file dependencies, meaning that
+	// editing code in one file can result in a different diagnostic
+	// set in another file.
+	InterFileDependencies bool `json:"interFileDependencies"`
+
+	// The server provides support for workspace diagnostics as well.
+	WorkspaceDiagnostics bool `json:"workspaceDiagnostics"`
+
+	WorkDoneProgressOptions
+}
+
+// ToDiagnosticOptions converts the DiagnosticProvider server capability
+// to DiagnosticOptions.
+func ToDiagnosticOptions(v interface{}) (*DiagnosticOptions, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var opt DiagnosticOptions
+	err = json.Unmarshal(b, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// DocumentDiagnosticParams are the parameters of the document diagnostic request.
+type DocumentDiagnosticParams struct {
+	// The text document.
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+
+	// The additional identifier provided during registration.
+	Identifier string `json:"identifier,omitempty"`
+
+	// The result id of a previous response if provided.
+	PreviousResultID string `json:"previousResultId,omitempty"`
+
+	WorkDoneProgressParams
+	PartialResultParams
+}
+
+// DocumentDiagnosticReportKind is the kind of a document diagnostic report.
+type DocumentDiagnosticReportKind string
+
+const (
+	// DiagnosticFull is a report containing a full set of problems.
+	DiagnosticFull DocumentDiagnosticReportKind = "full"
+
+	// DiagnosticUnchanged is a report indicating that nothing has
+	// changed in terms of diagnostics compared to a previous report.
+	DiagnosticUnchanged DocumentDiagnosticReportKind = "unchanged"
+)
+
+// DocumentDiagnosticReport is the result of a document diagnostic request.
+// It represents the union of full and unchanged document diagnostic reports.
+type DocumentDiagnosticReport struct {
+	// Kind is either "full" or "unchanged".
+	Kind DocumentDiagnosticReportKind `json:"kind"`
+
+	// An optional result id. If provided it will be sent on the next
+	// diagnostic request for the same document. For an unchanged
+	// report, it's the result id of the previous full report.
+	ResultID string `json:"resultId,omitempty"`
+
+	// The actual items. Only set for full reports.
+	Items []Diagnostic `json:"items,omitempty"`
+
+	// Diagnostics of related documents. This information is useful in
+	// programming languages where code in a file A can generate
+	// diagnostics in a file B which A depends on.
+	RelatedDocuments map[DocumentURI]DocumentDiagnosticReport `json:"relatedDocuments,omitempty"`
+}
+
+// PreviousResultID is a previous result id in a workspace pull request.
+type PreviousResultID struct {
+	// The URI for which the client knows a result id.
+	URI DocumentURI `json:"uri"`
+
+	// The value of the previous result id.
+	Value string `json:"value"`
+}
+
+// WorkspaceDiagnosticParams are the parameters of the workspace diagnostic request.
+type WorkspaceDiagnosticParams struct {
+	// The additional identifier provided during registration.
+	Identifier string `json:"identifier,omitempty"`
+
+	// The currently known diagnostic reports with their previous result ids.
+	PreviousResultIds []PreviousResultID `json:"previousResultIds"`
+
+	WorkDoneProgressParams
+	PartialResultParams
+}
+
+// WorkspaceDocumentDiagnosticReport is a document diagnostic report
+// for a workspace diagnostic result.
+type WorkspaceDocumentDiagnosticReport struct {
+	DocumentDiagnosticReport
+
+	// The URI for which diagnostic information is reported.
+	URI DocumentURI `json:"uri"`
+
+	// The version number for which the diagnostics are reported.
+	// If the document is not marked as open null can be provided.
+	Version *float64 `json:"version"`
+}
+
+// WorkspaceDiagnosticReport is the result of a workspace diagnostic request.
+type WorkspaceDiagnosticReport struct {
+	Items []WorkspaceDocumentDiagnosticReport `json:"items"`
+}
+
+// DiagnosticServer is implemented by the Server returned by NewClient.
+// It's separate from Server because the generated interface predates
+// pull model diagnostics.
+type DiagnosticServer interface {
+	Diagnostic(context.Context, *DocumentDiagnosticParams) (*DocumentDiagnosticReport, error)
+	WorkspaceDiagnostic(context.Context, *WorkspaceDiagnosticParams) (*WorkspaceDiagnosticReport, error)
+}
+
+func (s *serverDispatcher) Diagnostic(ctx context.Context, params *DocumentDiagnosticParams) (*DocumentDiagnosticReport, error) {
+	var result DocumentDiagnosticReport
+	if err := s.Conn.Call(ctx, "textDocument/diagnostic", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *serverDispatcher) WorkspaceDiagnostic(ctx context.Context, params *WorkspaceDiagnosticParams) (*WorkspaceDiagnosticReport, error) {
+	var result WorkspaceDiagnosticReport
+	if err := s.Conn.Call(ctx, "workspace/diagnostic", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DiagnosticClient is optionally implemented by the Client given to NewClient
+// to handle workspace/diagnostic/refresh requests.
+type DiagnosticClient interface {
+	DiagnosticRefresh(context.Context) error
+}
+
+type diagnosticClientHandler struct {
+	jsonrpc2.EmptyHandler
+	client DiagnosticClient
+}
+
+func (h diagnosticClientHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered bool) bool {
+	if delivered {
+		return false
+	}
+	switch r.Method {
+	case "workspace/diagnostic/refresh": // req
+		if r.Params != nil {
+			r.Reply(ctx, nil, jsonrpc2.NewErrorf(jsonrpc2.CodeInvalidParams, "Expected no params"))
+			return true
+		}
+		err := h.client.DiagnosticRefresh(ctx)
+		if err := r.Reply(ctx, nil, err); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
+	default:
+		return false
+	}
+}
@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/jsonrpc2"
+	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/telemetry/log"
+)
+
+// Server initiated work done progress was added in LSP 3.15, but the
+// generated Client interface doesn't include it. The types and handler here
+// are written by hand following the specification:
+// https://microsoft.github.io/language-server-protocol/specifications/specification-3-15/#workDoneProgress
+
+// WindowClientCapabilities are the window specific client capabilities.
+type WindowClientCapabilities struct {
+	// Whether client supports handling progress notifications. If set
+	// servers are allowed to report in `workDoneProgress` property in the
+	// request specific server capabilities.
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+}
+
+// WorkDoneProgressCreateParams are the parameters of the
+// window/workDoneProgress/create request.
+type WorkDoneProgressCreateParams struct {
+	// The token to be used to report progress.
+	Token ProgressToken `json:"token"`
+}
+
+// Kinds of work done progress.
+const (
+	ProgressBegin  = "begin"
+	ProgressReport = "report"
+	ProgressEnd    = "end"
+)
+
+// WorkDoneProgress is the value of a $/progress notification reporting
+// work done progress. It represents the union of WorkDoneProgressBegin,
+// WorkDoneProgressReport and WorkDoneProgressEnd.
+type WorkDoneProgress struct {
+	// Kind is one of ProgressBegin, ProgressReport or ProgressEnd.
+	Kind string `json:"kind"`
+
+	// Mandatory title of the progress operation. Only set for
+	// ProgressBegin.
+	Title string `json:"title,omitempty"`
+
+	// Controls if a cancel button should show to allow the user to
+	// cancel the long running operation.
+	Cancellable bool `json:"cancellable,omitempty"`
+
+	// Optional, more detailed associated progress message.
+	Message string `json:"message,omitempty"`
+
+	// Optional progress percentage to display (value 100 is considered
+	// 100%). Not set for ProgressEnd.
+	Percentage float64 `json:"percentage,omitempty"`
+}
+
+// ToWorkDoneProgress converts the value of a $/progress notification
+// to WorkDoneProgress. The value may instead be a partial result, in
+// which case the returned Kind will be empty.
+func ToWorkDoneProgress(v interface{}) (*WorkDoneProgress, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var wdp WorkDoneProgress
+	if b[0] != '{' {
+		return &wdp, nil // partial result array
+	}
+	err = json.Unmarshal(b, &wdp)
+	if err != nil {
+		return nil, err
+	}
+	return &wdp, nil
+}
+
+// ProgressClient is optionally implemented by the Client given to NewClient
+// to handle progress reported by the server.
+type ProgressClient interface {
+	Progress(context.Context, *ProgressParams) error
+	WorkDoneProgressCreate(context.Context, *WorkDoneProgressCreateParams) error
+}
+
+type progressClientHandler struct {
+	jsonrpc2.EmptyHandler
+	client ProgressClient
+}
+
+func (h progressClientHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered bool) bool {
+	if delivered {
+		return false
+	}
+	switch r.Method {
+	case "$/progress": // notif
+		var params ProgressParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			sendParseError(ctx, r, err)
+			return true
+		}
+		if err := h.client.Progress(ctx, &params); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
+	case "window/workDoneProgress/create": // req
+		var params WorkDoneProgressCreateParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			sendParseError(ctx, r, err)
+			return true
+		}
+		err := h.client.WorkDoneProgressCreate(ctx, &params)
+		if err := r.Reply(ctx, nil, err); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
+	default:
+		return false
+	}
+}
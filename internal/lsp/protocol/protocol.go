@@ -60,9 +60,29 @@ func NewClient(ctx context.Context, stream jsonrpc2.Stream, client Client) (cont
 	ctx = WithClient(ctx, client)
 	conn := jsonrpc2.NewConn(stream)
 	conn.AddHandler(&clientHandler{client: client})
+	if dc, ok := client.(DiagnosticClient); ok {
+		conn.AddHandler(&diagnosticClientHandler{client: dc})
+	}
+	if pc, ok := client.(ProgressClient); ok {
+		conn.AddHandler(&progressClientHandler{client: pc})
+	}
+	conn.AddHandler(parallelHandler{})
 	return ctx, conn, &serverDispatcher{Conn: conn}
 }
 
+// parallelHandler lets the messages following a request that may take a long
+// time to handle (e.g. because it's waiting for the user to respond)
+// be handled without waiting for the request to complete.
+type parallelHandler struct{ jsonrpc2.EmptyHandler }
+
+func (parallelHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered bool) bool {
+	switch r.Method {
+	case "window/showMessageRequest":
+		r.Parallel()
+	}
+	return false
+}
+
 func NewServer(ctx context.Context, stream jsonrpc2.Stream, server Server) (context.Context, *jsonrpc2.Conn, Client) {
 	conn := jsonrpc2.NewConn(stream)
 	client := &clientDispatcher{Conn: conn}
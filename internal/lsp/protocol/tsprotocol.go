@@ -692,6 +692,13 @@ type TextDocumentClientCapabilities struct {
 	 * Capabilities specific to `textDocument/publishDiagnostics`.
 	 */
 	PublishDiagnostics *PublishDiagnosticsClientCapabilities `json:"publishDiagnostics,omitempty"`
+
+	/*Diagnostic defined:
+	 * Capabilities specific to the diagnostic pull model.
+	 *
+	 * @since 3.17.0
+	 */
+	Diagnostic *DiagnosticClientCapabilities `json:"diagnostic,omitempty"`
 }
 
 /*InnerClientCapabilities defined:
@@ -769,6 +776,14 @@ type ClientCapabilities struct {
 		* The client supports `workspace/configuration` requests.
 		 */
 		Configuration bool `json:"configuration,omitempty"`
+
+		/*Diagnostics defined:
+		 * Capabilities specific to the diagnostic requests scoped to the
+		 * workspace.
+		 *
+		 * @since 3.17.0
+		 */
+		Diagnostics *DiagnosticWorkspaceClientCapabilities `json:"diagnostics,omitempty"`
 	} `json:"workspace,omitempty"`
 
 	/*TextDocument defined:
@@ -1092,6 +1107,13 @@ type ServerCapabilities struct {
 	 */
 	ExecuteCommandProvider *ExecuteCommandOptions `json:"executeCommandProvider,omitempty"`
 
+	/*DiagnosticProvider defined:
+	 * The server has support for pull model diagnostics.
+	 *
+	 * @since 3.17.0
+	 */
+	DiagnosticProvider interface{} `json:"diagnosticProvider,omitempty"` // DiagnosticOptions | DiagnosticRegistrationOptions
+
 	/*Experimental defined:
 	 * Experimental server capabilities.
 	 */
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/jsonrpc2"
 	"github.com/fhs/acme-lsp/internal/golang_org_x_tools/telemetry/log"
@@ -11,7 +13,7 @@ import (
 )
 
 // Version is used to detect if acme-lsp and L are speaking the same protocol.
-const Version = 1
+const Version = 4
 
 // Server implements a subset of an LSP protocol server as defined by protocol.Server and
 // some custom acme-lsp specific methods.
@@ -35,6 +37,24 @@ type Server interface {
 	// ExecuteCommand request to the right server.
 	ExecuteCommandOnDocument(context.Context, *ExecuteCommandOnDocumentParams) (interface{}, error)
 
+	// WorkDoneProgress returns the work done progress currently being
+	// reported by the LSP servers (e.g. loading packages).
+	WorkDoneProgress(context.Context) ([]WorkDoneProgressStatus, error)
+
+	// Servers returns the status of the configured LSP servers.
+	Servers(context.Context) ([]ServerStatus, error)
+
+	// RestartServer restarts the LSP servers with the given key.
+	RestartServer(context.Context, *ServerKeyParams) error
+
+	// StopServer stops the LSP servers with the given key. They will
+	// be started again when they are needed.
+	StopServer(context.Context, *ServerKeyParams) error
+
+	// Reload reloads the configuration file of acme-lsp, restarting the
+	// LSP servers whose configuration changed.
+	Reload(context.Context) error
+
 	DidChange(context.Context, *protocol.DidChangeTextDocumentParams) error
 	DidChangeWorkspaceFolders(context.Context, *protocol.DidChangeWorkspaceFoldersParams) error
 	Completion(context.Context, *protocol.CompletionParams) (*protocol.CompletionList, error)
@@ -90,6 +110,51 @@ func (h serverHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, deliver
 		}
 		return true
 
+	case "acme-lsp/workDoneProgress": // req
+		resp, err := h.server.WorkDoneProgress(ctx)
+		if err := r.Reply(ctx, resp, err); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
+	case "acme-lsp/servers": // req
+		resp, err := h.server.Servers(ctx)
+		if err := r.Reply(ctx, resp, err); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
+	case "acme-lsp/restartServer": // req
+		var params ServerKeyParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			sendParseError(ctx, r, err)
+			return true
+		}
+		err := h.server.RestartServer(ctx, &params)
+		if err := r.Reply(ctx, nil, err); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
+	case "acme-lsp/stopServer": // req
+		var params ServerKeyParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			sendParseError(ctx, r, err)
+			return true
+		}
+		err := h.server.StopServer(ctx, &params)
+		if err := r.Reply(ctx, nil, err); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
+	case "acme-lsp/reload": // req
+		err := h.server.Reload(ctx)
+		if err := r.Reply(ctx, nil, err); err != nil {
+			log.Error(ctx, "", err)
+		}
+		return true
+
 	case "acme-lsp/executeCommandOnDocument": // req
 		var params ExecuteCommandOnDocumentParams
 		if err := json.Unmarshal(*r.Params, &params); err != nil {
@@ -144,6 +209,81 @@ func (s *serverDispatcher) ExecuteCommandOnDocument(ctx context.Context, params
 	return result, nil
 }
 
+func (s *serverDispatcher) WorkDoneProgress(ctx context.Context) ([]WorkDoneProgressStatus, error) {
+	var result []WorkDoneProgressStatus
+	if err := s.Conn.Call(ctx, "acme-lsp/workDoneProgress", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *serverDispatcher) Servers(ctx context.Context) ([]ServerStatus, error) {
+	var result []ServerStatus
+	if err := s.Conn.Call(ctx, "acme-lsp/servers", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *serverDispatcher) RestartServer(ctx context.Context, params *ServerKeyParams) error {
+	return s.Conn.Call(ctx, "acme-lsp/restartServer", params, nil)
+}
+
+func (s *serverDispatcher) StopServer(ctx context.Context, params *ServerKeyParams) error {
+	return s.Conn.Call(ctx, "acme-lsp/stopServer", params, nil)
+}
+
+func (s *serverDispatcher) Reload(ctx context.Context) error {
+	return s.Conn.Call(ctx, "acme-lsp/reload", nil, nil)
+}
+
+// ServerKeyParams identifies LSP servers by the key used in the
+// configuration file.
+type ServerKeyParams struct {
+	Key string
+}
+
+// ServerStatus describes a configured LSP server and its running
+// instance, if any.
+type ServerStatus struct {
+	Key           string    // key in the configuration file
+	Pattern       string    // regular expression that matches filenames
+	Command       []string  // command used to start the server
+	Address       string    // dial address of the server
+	Root          string    // project root, if the server is started per project root
+	Running       bool      // server is running or connected
+	PID           int       // process ID, or 0 if the server was dialed
+	Start         time.Time // when the server was last (re)started
+	Restarts      int       // number of times the server was restarted
+	OpenDocuments []string  // files opened in the server
+	Name          string    // server name reported in initialization
+	Version       string    // server version reported in initialization
+}
+
+// WorkDoneProgressStatus is the latest state of a work done progress
+// reported by a LSP server.
+type WorkDoneProgressStatus struct {
+	Server     string    // name of the LSP server
+	Title      string    // title of the operation
+	Message    string    // optional detailed message
+	Percentage float64   // optional percentage of work done
+	Start      time.Time // when the server started reporting progress
+}
+
+// String returns the progress title, message and percentage (e.g.
+// "Loading packages: 3/10 30%").
+func (s *WorkDoneProgressStatus) String() string {
+	var b strings.Builder
+	b.WriteString(s.Title)
+	if s.Message != "" {
+		fmt.Fprintf(&b, ": %v", s.Message)
+	}
+	if s.Percentage > 0 {
+		fmt.Fprintf(&b, " %v%%", s.Percentage)
+	}
+	return b.String()
+}
+
 type CancelParams struct {
 	/**
 	 * The request id to cancel.
@@ -0,0 +1,192 @@
+package text
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+)
+
+// ApplyEdits returns text b with edits applied, as Edit would apply
+// them to a file containing b.
+func ApplyEdits(b []byte, edits []protocol.TextEdit) ([]byte, error) {
+	f := bytesFile(append([]byte(nil), b...))
+	if err := Edit(&f, edits); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// bytesFile is a File stored in memory.
+type bytesFile []byte
+
+func (f *bytesFile) Reader() (io.Reader, error) {
+	return strings.NewReader(string(*f)), nil
+}
+
+func (f *bytesFile) WriteAt(q0, q1 int, b []byte) (int, error) {
+	r := []rune(string(*f))
+	rr := make([]rune, 0, len(r)+len(b))
+	rr = append(rr, r[:q0]...)
+	rr = append(rr, []rune(string(b))...)
+	rr = append(rr, r[q1:]...)
+	*f = []byte(string(rr))
+	return len(b), nil
+}
+
+func (f *bytesFile) Mark() error        { return nil }
+func (f *bytesFile) DisableMark() error { return nil }
+
+// maxDiffCost limits the work done by LineDiff. If the texts differ by
+// more lines than this, the lines between the common prefix and suffix
+// are reported as replaced.
+const maxDiffCost = 1000
+
+// LineDiff compares the lines of texts a and b. It returns the number
+// of lines of a that are deleted, the number of lines of b that are
+// inserted, and the differences in the unified diff format without
+// context lines.
+func LineDiff(a, b []byte) (deleted, inserted int, diff string) {
+	al, bl := splitLines(string(a)), splitLines(string(b))
+
+	// Trim the common prefix and suffix, which is usually most of the file.
+	pre := 0
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+	am, bm := al[pre:len(al)-suf], bl[pre:len(bl)-suf]
+
+	ops, ok := diffOps(am, bm)
+	if !ok {
+		ops = ops[:0]
+		for range am {
+			ops = append(ops, '-')
+		}
+		for range bm {
+			ops = append(ops, '+')
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0 // line indexes within am and bm
+	for k := 0; k < len(ops); {
+		if ops[k] == '=' {
+			i++
+			j++
+			k++
+			continue
+		}
+		// Hunk of consecutive deletions and insertions.
+		i0, j0 := i, j
+		var lines []string
+		for ; k < len(ops) && ops[k] != '='; k++ {
+			switch ops[k] {
+			case '-':
+				lines = append(lines, "-"+am[i])
+				i++
+			case '+':
+				lines = append(lines, "+"+bm[j])
+				j++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%v,%v +%v,%v @@\n", pre+i0+1, i-i0, pre+j0+1, j-j0)
+		for _, l := range lines {
+			sb.WriteString(l)
+			if !strings.HasSuffix(l, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		deleted += i - i0
+		inserted += j - j0
+	}
+	return deleted, inserted, sb.String()
+}
+
+// splitLines splits s into lines, each including its terminating newline
+// except possibly the last one.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOps returns the shortest edit script that transforms a into b as a
+// sequence of '=' (keep), '-' (delete from a) and '+' (insert from b),
+// using the Myers diff algorithm. It returns false if the edit script is
+// longer than maxDiffCost.
+func diffOps(a, b []string) ([]byte, bool) {
+	n, m := len(a), len(b)
+	// trace[d][k+d+1] is the furthest x reached on diagonal k
+	// (x-y = k) after d-1 edits, for k in [-d-1, d+1].
+	var trace [][]int
+	v := []int{0, 0, 0} // v[k+d+1] for the current d
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffCost {
+			return nil, false
+		}
+		trace = append(trace, v)
+		next := make([]int, 2*d+5)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+d+1] < v[k+1+d+1]) {
+				x = v[k+1+d+1] // insertion
+			} else {
+				x = v[k-1+d+1] + 1 // deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			next[k+d+2] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m), true
+			}
+		}
+		v = next
+	}
+	panic("unreachable")
+}
+
+func backtrack(trace [][]int, x, y int) []byte {
+	var ops []byte
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && v[k-1+d+1] < v[k+1+d+1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := v[pk+d+1]
+		py := px - pk
+		for x > px && y > py {
+			ops = append(ops, '=')
+			x--
+			y--
+		}
+		if x == px {
+			ops = append(ops, '+')
+		} else {
+			ops = append(ops, '-')
+		}
+		x, y = px, py
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, '=')
+		x--
+		y--
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,69 @@
+package text
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fhs/acme-lsp/internal/lsp/protocol"
+)
+
+func TestApplyEdits(t *testing.T) {
+	b := []byte("package main\n\nfunc  main() {\n}\n")
+	edits := []protocol.TextEdit{
+		{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: 2, Character: 4},
+				End:   protocol.Position{Line: 2, Character: 6},
+			},
+			NewText: " ",
+		},
+	}
+	got, err := ApplyEdits(b, edits)
+	if err != nil {
+		t.Fatalf("ApplyEdits failed: %v", err)
+	}
+	want := "package main\n\nfunc main() {\n}\n"
+	if string(got) != want {
+		t.Errorf("ApplyEdits returned %q; want %q", got, want)
+	}
+	if string(b) != "package main\n\nfunc  main() {\n}\n" {
+		t.Errorf("ApplyEdits modified its input: %q", b)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	for _, tc := range []struct {
+		a, b              string
+		deleted, inserted int
+		diff              string
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", 0, 0, ""},
+		{"", "a\n", 0, 1, "@@ -1,0 +1,1 @@\n+a\n"},
+		{"a\nb\nc\n", "a\nB\nc\n", 1, 1, "@@ -2,1 +2,1 @@\n-b\n+B\n"},
+		{
+			"a\nb\nc\nd\ne\n",
+			"x\na\nc\nd\ny\n",
+			2, 2,
+			"@@ -1,0 +1,1 @@\n+x\n@@ -2,1 +3,0 @@\n-b\n@@ -5,1 +5,1 @@\n-e\n+y\n",
+		},
+		{"a\nb", "a\nc", 1, 1, "@@ -2,1 +2,1 @@\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+	} {
+		deleted, inserted, diff := LineDiff([]byte(tc.a), []byte(tc.b))
+		if deleted != tc.deleted || inserted != tc.inserted || diff != tc.diff {
+			t.Errorf("LineDiff(%q, %q) = %v, %v, %q; want %v, %v, %q",
+				tc.a, tc.b, deleted, inserted, diff, tc.deleted, tc.inserted, tc.diff)
+		}
+	}
+}
+
+func TestLineDiffLimit(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < 2*maxDiffCost; i++ {
+		a.WriteString("a\n")
+		b.WriteString("b\n")
+	}
+	deleted, inserted, _ := LineDiff([]byte(a.String()), []byte(b.String()))
+	if deleted != 2*maxDiffCost || inserted != 2*maxDiffCost {
+		t.Errorf("LineDiff returned %v deleted and %v inserted lines; want %v", deleted, inserted, 2*maxDiffCost)
+	}
+}
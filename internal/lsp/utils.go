@@ -63,6 +63,96 @@ func CompatibleCodeActions(cap *protocol.ServerCapabilities, kinds []protocol.Co
 	return nil
 }
 
+// ServerDiagnosticOptions returns the pull model diagnostic options of the server,
+// or nil if the server only publishes diagnostics.
+func ServerDiagnosticOptions(cap *protocol.ServerCapabilities) *protocol.DiagnosticOptions {
+	if cap.DiagnosticProvider == nil {
+		return nil
+	}
+	opt, err := protocol.ToDiagnosticOptions(cap.DiagnosticProvider)
+	if err != nil {
+		log.Printf("failed to decode DiagnosticOptions: %v", err)
+		return nil
+	}
+	return opt
+}
+
+// ServerTextDocumentSyncOptions returns the text document synchronization
+// options of the server.
+func ServerTextDocumentSyncOptions(cap *protocol.ServerCapabilities) *protocol.TextDocumentSyncOptions {
+	if cap.TextDocumentSync == nil {
+		return &protocol.TextDocumentSyncOptions{}
+	}
+	opt, err := protocol.ToTextDocumentSyncOptions(cap.TextDocumentSync)
+	if err != nil {
+		log.Printf("failed to decode TextDocumentSyncOptions: %v", err)
+		return &protocol.TextDocumentSyncOptions{}
+	}
+	return opt
+}
+
+// ServerProvides returns true if the server supports the request method
+// (e.g. "textDocument/hover") according to its capabilities. Methods that
+// don't have an associated server capability are assumed to be supported.
+func ServerProvides(cap *protocol.ServerCapabilities, method string) bool {
+	switch method {
+	case "textDocument/completion":
+		return cap.CompletionProvider != nil
+	case "textDocument/hover":
+		return cap.HoverProvider
+	case "textDocument/signatureHelp":
+		return cap.SignatureHelpProvider != nil
+	case "textDocument/definition":
+		return cap.DefinitionProvider
+	case "textDocument/typeDefinition":
+		return cap.TypeDefinitionProvider
+	case "textDocument/implementation":
+		return cap.ImplementationProvider
+	case "textDocument/references":
+		return cap.ReferencesProvider
+	case "textDocument/documentSymbol":
+		return cap.DocumentSymbolProvider
+	case "textDocument/formatting":
+		return cap.DocumentFormattingProvider
+	case "textDocument/codeAction":
+		return providerEnabled(cap.CodeActionProvider)
+	case "textDocument/rename":
+		return providerEnabled(cap.RenameProvider)
+	case "workspace/executeCommand":
+		return cap.ExecuteCommandProvider != nil
+	case "textDocument/willSave":
+		return ServerTextDocumentSyncOptions(cap).WillSave
+	case "textDocument/willSaveWaitUntil":
+		return ServerTextDocumentSyncOptions(cap).WillSaveWaitUntil
+	}
+	return true
+}
+
+// providerEnabled returns true if the server capability v, which is either
+// a boolean or an options object, is enabled.
+func providerEnabled(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// ServerProvidesCommand returns true if the server can execute command.
+func ServerProvidesCommand(cap *protocol.ServerCapabilities, command string) bool {
+	if cap.ExecuteCommandProvider == nil {
+		return false
+	}
+	for _, c := range cap.ExecuteCommandProvider.Commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
 func LocationLink(l *protocol.Location) string {
 	p := text.ToPath(l.URI)
 	return fmt.Sprintf("%s:%v:%v-%v:%v", p,
@@ -92,15 +182,20 @@ func DidClose(ctx context.Context, server protocol.Server, filename string) erro
 	})
 }
 
-func DidSave(ctx context.Context, server protocol.Server, filename string) error {
-	return server.DidSave(ctx, &protocol.DidSaveTextDocumentParams{
+// DidSave tells the server that filename was saved. The saved content,
+// body, is included if it's not nil.
+func DidSave(ctx context.Context, server protocol.Server, filename string, body []byte) error {
+	params := &protocol.DidSaveTextDocumentParams{
 		TextDocument: protocol.VersionedTextDocumentIdentifier{
 			TextDocumentIdentifier: protocol.TextDocumentIdentifier{
 				URI: text.ToURI(filename),
 			},
-			// TODO(fhs): add text field for includeText option
 		},
-	})
+	}
+	if body != nil {
+		params.Text = string(body)
+	}
+	return server.DidSave(ctx, params)
 }
 
 func DidChange(ctx context.Context, server protocol.Server, filename string, body []byte) error {
@@ -83,3 +83,43 @@ func TestCompatibleCodeActions(t *testing.T) {
 		})
 	}
 }
+
+func TestServerProvides(t *testing.T) {
+	cap := &protocol.ServerCapabilities{
+		CodeActionProvider: map[string]interface{}{
+			"codeActionKinds": []interface{}{"quickfix"},
+		},
+		RenameProvider: false,
+		TextDocumentSync: map[string]interface{}{
+			"change":            2.0,
+			"willSaveWaitUntil": true,
+		},
+	}
+	cap.HoverProvider = true
+	cap.ExecuteCommandProvider = &protocol.ExecuteCommandOptions{
+		Commands: []string{"gopls.tidy"},
+	}
+	for _, tc := range []struct {
+		method string
+		want   bool
+	}{
+		{"textDocument/hover", true},
+		{"textDocument/definition", false},
+		{"textDocument/completion", false},
+		{"textDocument/codeAction", true},
+		{"textDocument/rename", false},
+		{"textDocument/didOpen", true},
+		{"textDocument/willSave", false},
+		{"textDocument/willSaveWaitUntil", true},
+	} {
+		if got := ServerProvides(cap, tc.method); got != tc.want {
+			t.Errorf("ServerProvides for %v returned %v; want %v", tc.method, got, tc.want)
+		}
+	}
+	if !ServerProvidesCommand(cap, "gopls.tidy") {
+		t.Errorf("ServerProvidesCommand returned false for provided command")
+	}
+	if ServerProvidesCommand(cap, "gopls.vendor") {
+		t.Errorf("ServerProvidesCommand returned true for unknown command")
+	}
+}